@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	errNotArchive      = errors.New("not an archive")
+	errArchiveTooLarge = errors.New("decompressed size limit exceeded")
+)
+
+var xzMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+// archiveKind identifies an archive or compression format from the
+// first 512 bytes of a file, returning "" for anything else.
+func archiveKind(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, zipMagic):
+		return "zip"
+	case bytes.HasPrefix(header, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(header, bzip2Magic):
+		return "bzip2"
+	case bytes.HasPrefix(header, xzMagic):
+		return "xz"
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return "tar"
+	}
+	return ""
+}
+
+// archiveBudget is the number of bytes that may still be decompressed
+// from an archive, shared by all of the archives within it.
+type archiveBudget struct {
+	remaining int64
+}
+
+// budgetReader charges the bytes read from r to a budget, failing with
+// errArchiveTooLarge once it is spent.
+type budgetReader struct {
+	r      io.Reader
+	budget *archiveBudget
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if b.budget.remaining <= 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > b.budget.remaining {
+		p = p[:b.budget.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.budget.remaining -= int64(n)
+	return n, err
+}
+
+// memberInfo describes an archive member that has no file info of its
+// own, such as the contents of a gzip file.
+type memberInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (m memberInfo) Name() string       { return m.name }
+func (m memberInfo) Size() int64        { return m.size }
+func (m memberInfo) Mode() os.FileMode  { return 0644 }
+func (m memberInfo) ModTime() time.Time { return m.modTime }
+func (m memberInfo) IsDir() bool        { return false }
+func (m memberInfo) Sys() interface{}   { return nil }
+
+// walkArchive reports the members of the archive named name read from
+// r. It returns errNotArchive if r isn't an archive. Zip archives must
+// be read from a file or a *bytes.Reader, since their directory is at
+// the end.
+func (s *Scanner) walkArchive(root, name string, r io.Reader, depth int, budget *archiveBudget, fn scanFunc) error {
+	buffered := bufio.NewReaderSize(r, 1024)
+	header, _ := buffered.Peek(512)
+	switch archiveKind(header) {
+	case "zip":
+		ra, size, err := readerAtSize(r)
+		if err != nil {
+			return err
+		}
+		return s.walkZip(root, name, ra, size, depth, budget, fn)
+	case "tar":
+		return s.walkTar(root, name, buffered, depth, budget, fn)
+	case "gzip":
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		info := memberInfo{name: gz.Name, modTime: gz.ModTime}
+		return s.walkCompressed(root, name, gz, info, depth, budget, fn)
+	case "bzip2":
+		return s.walkCompressed(root, name, bzip2.NewReader(buffered), memberInfo{}, depth, budget, fn)
+	case "xz":
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		return s.walkCompressed(root, name, xzReader, memberInfo{}, depth, budget, fn)
+	}
+	return errNotArchive
+}
+
+// readerAtSize gives random access to the readers walkArchive is handed
+// for zip archives.
+func readerAtSize(r io.Reader) (io.ReaderAt, int64, error) {
+	switch f := r.(type) {
+	case *bytes.Reader:
+		return f, f.Size(), nil
+	case interface {
+		io.ReaderAt
+		Stat() (os.FileInfo, error)
+	}:
+		info, err := f.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	}
+	return nil, 0, errors.New("zip archive can't be read from a stream")
+}
+
+// memberName cleans the name of a member of an archive found depth
+// levels down. Members of the outermost archive lose StripComponents
+// leading directories, and "" is returned for those without that many.
+func (s *Scanner) memberName(name string, depth int) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if depth > 1 || s.StripComponents <= 0 {
+		return name
+	}
+	parts := strings.SplitN(name, "/", s.StripComponents+1)
+	if len(parts) <= s.StripComponents {
+		return ""
+	}
+	return parts[s.StripComponents]
+}
+
+func (s *Scanner) walkZip(root, name string, ra io.ReaderAt, size int64, depth int, budget *archiveBudget, fn scanFunc) error {
+	archive, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	for _, member := range archive.File {
+		if !member.Mode().IsRegular() {
+			continue
+		}
+		inner := s.memberName(member.Name, depth)
+		if inner == "" {
+			continue
+		}
+		r, err := member.Open()
+		if err != nil {
+			return err
+		}
+		memberName := name + "!" + inner
+		limited := &budgetReader{r: r, budget: budget}
+		err = s.member(root, memberName, member.FileInfo(), limited, depth, budget, fn)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if budget.remaining <= 0 {
+			return errArchiveTooLarge
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) walkTar(root, name string, r io.Reader, depth int, budget *archiveBudget, fn scanFunc) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		info := header.FileInfo()
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		inner := s.memberName(header.Name, depth)
+		if inner == "" {
+			continue
+		}
+		memberName := name + "!" + inner
+		if err := s.member(root, memberName, info, archive, depth, budget, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// walkCompressed handles a compressed stream, which holds either a tar
+// archive or a single file.
+func (s *Scanner) walkCompressed(root, name string, r io.Reader, info memberInfo, depth int, budget *archiveBudget, fn scanFunc) error {
+	limited := bufio.NewReaderSize(&budgetReader{r: r, budget: budget}, 1024)
+	header, _ := limited.Peek(512)
+	if archiveKind(header) == "tar" {
+		return s.walkTar(root, name, limited, depth, budget, fn)
+	}
+	if info.name == "" {
+		base := path.Base(name)
+		info.name = strings.TrimSuffix(base, path.Ext(base))
+	}
+	return s.member(root, name+"!"+info.name, info, limited, depth, budget, fn)
+}
+
+// member reports one archive member and, if it is itself an archive,
+// the members within it.
+func (s *Scanner) member(root, name string, info os.FileInfo, r io.Reader, depth int, budget *archiveBudget, fn scanFunc) error {
+	buffered := bufio.NewReaderSize(r, 1024)
+	header, _ := buffered.Peek(512)
+	if archiveKind(header) == "" {
+		if err := fn(&scannedFile{Path: name, Root: root, Info: info, Reader: buffered}); err != nil {
+			return &scanError{err}
+		}
+		return nil
+	}
+
+	// Archives within archives are read into memory so they can be
+	// hashed and then opened in turn, but no more than the budget allows.
+	limit := budget.remaining
+	content, err := ioutil.ReadAll(io.LimitReader(buffered, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) > limit {
+		return errArchiveTooLarge
+	}
+	if err := fn(&scannedFile{Path: name, Root: root, Info: info, Reader: bytes.NewReader(content)}); err != nil {
+		return &scanError{err}
+	}
+	if depth >= s.MaxDepth {
+		fmt.Fprintf(s.Out, "Not opening archive %s: nested more than %d deep\n", name, s.MaxDepth)
+		return nil
+	}
+	err = s.walkArchive(root, name, bytes.NewReader(content), depth+1, budget, fn)
+	if _, ok := err.(*scanError); ok || err == errArchiveTooLarge {
+		return err
+	}
+	if err != nil && err != errNotArchive {
+		fmt.Fprintf(s.Out, "Error reading archive %s: %v\n", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/ulikunitz/xz"
+)
+
+func makeZip(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w.Write(content)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func makeTar(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		tw.Write(content)
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func makeGzip(content []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(content)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func makeXz(content []byte) []byte {
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Write(content)
+	w.Close()
+	return buf.Bytes()
+}
+
+func scannedPaths(scanner *Scanner, root string) []string {
+	var paths []string
+	err := scanner.Walk(root, func(f *scannedFile) error {
+		io.Copy(ioutil.Discard, f.Reader)
+		paths = append(paths, f.Path)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestScanArchives(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+
+	inner := makeTar(map[string][]byte{"./lib/inner.php": []byte("<?php")})
+	afero.WriteFile(fs, fakeDir+"release.zip", makeZip(map[string][]byte{
+		"wordpress/index.php":     []byte("index"),
+		"wordpress/plugin.tar.gz": makeGzip(inner),
+	}), 0644)
+	afero.WriteFile(fs, fakeDir+"backup.tar.xz", makeXz(inner), 0644)
+
+	scanner := NewScanner(fs)
+	paths := scannedPaths(scanner, fakeDir)
+	expected := []string{
+		fakeDir + "backup.tar.xz",
+		fakeDir + "release.zip",
+	}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Errorf(
+			"Scanner: Walk without archives: expected: %v actual: %v",
+			expected,
+			paths,
+		)
+	}
+
+	scanner.Archives = true
+	paths = scannedPaths(scanner, fakeDir)
+	expected = []string{
+		fakeDir + "backup.tar.xz",
+		fakeDir + "backup.tar.xz!lib/inner.php",
+		fakeDir + "release.zip",
+		fakeDir + "release.zip!wordpress/index.php",
+		fakeDir + "release.zip!wordpress/plugin.tar.gz",
+		fakeDir + "release.zip!wordpress/plugin.tar.gz!lib/inner.php",
+	}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Errorf(
+			"Scanner: Walk with archives: expected: %v actual: %v",
+			expected,
+			paths,
+		)
+	}
+
+	scanner.MaxDepth = 1
+	paths = scannedPaths(scanner, fakeDir+"release.zip")
+	for _, path := range paths {
+		if strings.Count(path, "!") > 1 {
+			t.Errorf("Scanner: MaxDepth 1: unexpected nested member: %s", path)
+		}
+	}
+
+	if count := scanner.Count(fakeDir); count != 2 {
+		t.Errorf("Scanner: Count: expected: %d actual: %d", 2, count)
+	}
+}
+
+func TestScanArchiveSizeLimit(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+	bomb := makeTar(map[string][]byte{
+		"a.txt": bytes.Repeat([]byte("a"), 4096),
+		"b.txt": bytes.Repeat([]byte("b"), 4096),
+	})
+	afero.WriteFile(fs, fakeDir+"bomb.tar.gz", makeGzip(bomb), 0644)
+
+	scanner := NewScanner(fs)
+	scanner.Archives = true
+	scanner.MaxSize = 2048
+	paths := scannedPaths(scanner, fakeDir)
+	members := 0
+	for _, path := range paths {
+		if strings.Contains(path, "!") {
+			members++
+		}
+	}
+	if members > 1 {
+		t.Errorf("Scanner: MaxSize: expected to stop after the first member: %v", paths)
+	}
+}
+
+func TestCalculateAndLookupArchives(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+	afero.WriteFile(fs, fakeDir+"app.war", makeZip(map[string][]byte{
+		"WEB-INF/web.xml": []byte("<web-app/>"),
+	}), 0644)
+
+	fakeFilterDir := "/tmp/filters/"
+	fs.MkdirAll(fakeFilterDir, 0755)
+	args := []string{"mdd", "calculate", "--archives", fakeFilterDir + "filterfile", fakeDir}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Calculate()
+
+	bloomFilter := NewBloomFilter(1, 0.01, fs)
+	bloomFilter.Load(fakeFilterDir + "filterfile")
+	digest, _ := hashReader(strings.NewReader("<web-app/>"), "md5")
+	if !bloomFilter.Lookup(digest) {
+		t.Errorf("Calculate: --archives: expected to find member digest: %s", digest)
+	}
+}
+
+func TestCalculateFromArchive(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+	afero.WriteFile(fs, fakeDir+"wordpress-6.4.zip", makeZip(map[string][]byte{
+		"wordpress/index.php":          []byte("index"),
+		"wordpress/wp-includes/wp.php": []byte("wp"),
+		"README":                       []byte("readme"),
+	}), 0644)
+	afero.WriteFile(fs, fakeDir+"wordpress-6.3.tar.gz", makeGzip(makeTar(map[string][]byte{
+		"wordpress/index.php": []byte("old index"),
+	})), 0644)
+
+	scanner := NewScanner(fs)
+	scanner.FromArchive = true
+	scanner.StripComponents = 1
+	var rels []string
+	err := scanner.Walk(fakeDir+"wordpress-6.4.zip", func(f *scannedFile) error {
+		rels = append(rels, f.Rel)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Scanner: FromArchive: unexpected error: %v", err)
+	}
+	sort.Strings(rels)
+	expected := []string{"index.php", "wp-includes/wp.php"}
+	if strings.Join(rels, " ") != strings.Join(expected, " ") {
+		t.Errorf(
+			"Scanner: StripComponents: expected: %v actual: %v",
+			expected,
+			rels,
+		)
+	}
+
+	if err := scanner.Walk(fakeDir, func(f *scannedFile) error { return nil }); err == nil {
+		t.Errorf("Scanner: FromArchive: expected an error for a directory")
+	}
+
+	fakeFilterDir := "/tmp/filters/"
+	fs.MkdirAll(fakeFilterDir, 0755)
+	args := []string{
+		"mdd",
+		"calculate",
+		"--from-archive",
+		"--strip-components=1",
+		"--mtree",
+		fakeFilterDir + "wordpress.mtree",
+		fakeFilterDir + "filterfile",
+		fakeDir + "wordpress-6.4.zip",
+		fakeDir + "wordpress-6.3.tar.gz",
+	}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Calculate()
+
+	bloomFilter := NewBloomFilter(1, 0.01, fs)
+	bloomFilter.Load(fakeFilterDir + "filterfile")
+	for _, content := range []string{"index", "wp", "old index"} {
+		digest, _ := hashReader(strings.NewReader(content), "md5")
+		if !bloomFilter.Lookup(digest) {
+			t.Errorf("Calculate: --from-archive: expected to find digest of %q", content)
+		}
+	}
+	digest, _ := hashReader(strings.NewReader("readme"), "md5")
+	if bloomFilter.Lookup(digest) {
+		t.Errorf("Calculate: --strip-components: expected README to be skipped")
+	}
+
+	spec, _ := afero.ReadFile(fs, fakeFilterDir+"wordpress.mtree")
+	if !strings.Contains(string(spec), "./wp-includes/wp.php ") {
+		t.Errorf("Calculate: --from-archive: expected stripped paths in spec: %s", spec)
+	}
+}
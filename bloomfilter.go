@@ -3,9 +3,11 @@ package main
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
 	"math"
-	"os"
+	"math/bits"
+	"path/filepath"
 
 	"github.com/roberson-io/mmh3"
 	"github.com/spf13/afero"
@@ -60,10 +62,35 @@ func NewBloomFilter(expectedItems int32, fpRate float64, fs afero.Fs) BloomFilte
 		Bitfield: make([]byte, bf.ByteSize),
 	}
 	bf.ByteSizeHuman = byteSizeHuman(bf.Size)
+	bf.Digest = "md5"
+	bf.Role = "known-good"
 	bf.Fs = fs
 	return bf
 }
 
+// NewScalableBloomFilter constructs a Bloom filter for when the number
+// of elements isn't known up front. It starts out sized for
+// expectedItems. Once that many elements have been added, a slice with
+// twice the capacity and half the false positive rate is chained on,
+// and so on, which keeps the rate for the whole chain below fpRate.
+func NewScalableBloomFilter(expectedItems int32, fpRate float64, fs afero.Fs) BloomFilter {
+	// Larger estimates are left to the slices chained on later.
+	if max := maxSliceItems(fpRate / 2); expectedItems > max || expectedItems < 0 {
+		expectedItems = max
+	}
+	bf := NewBloomFilter(expectedItems, fpRate/2, fs)
+	bf.Capacity = expectedItems
+	bf.FPRate = fpRate / 2
+	return bf
+}
+
+// maxSliceItems is the most elements a slice of a scalable filter can
+// be sized for at a given false positive rate without its size in bits
+// overflowing.
+func maxSliceItems(fpRate float64) int32 {
+	return int32(math.MaxInt32 / (-math.Log(fpRate) / math.Pow(math.Log(2.0), 2)))
+}
+
 // BloomFilter implements Bloom filter. You should probably use
 // NewBloomFilter unless you know what you're doing.
 type BloomFilter struct {
@@ -72,11 +99,97 @@ type BloomFilter struct {
 	Filter        BitField
 	ByteSize      int32
 	ByteSizeHuman string
-	Fs            afero.Fs
+	// Digest is the hash algorithm of the digests stored in the filter.
+	Digest string
+	// Role says whether matches are known good files, known bad ones,
+	// or only of note, as one of filterRoles.
+	Role string
+	// Normalize says how text files are normalized before hashing.
+	Normalize textNormalization
+	// Paths says whether the filter also holds the relative path of each
+	// file, alone and with its digest, so that lookups can tell a known
+	// file that was modified from a new one.
+	Paths bool
+	// Sidecar, when set, holds the filter's elements exactly. Elements
+	// added are recorded in it, Save writes it next to the filter, and
+	// Contains uses it to confirm matches.
+	Sidecar *Sidecar
+	// Name is the base name of the file the filter was loaded from.
+	Name string
+	Fs   afero.Fs
+	// Spec, when set, records every file and directory visited by
+	// CalculateHashes.
+	Spec *MtreeSpec
+	// Scanner, when set, is used by CalculateHashes and LookupHashes to
+	// walk files instead of a default Scanner for Fs.
+	Scanner *Scanner
+	// Cache, when set, holds digests of files from earlier scans, which
+	// CalculateHashes and LookupHashes use instead of reading files
+	// that haven't changed.
+	Cache *HashCache
+	// Capacity is the number of elements a scalable filter takes before
+	// chaining on the Next slice. It is zero for fixed size filters.
+	Capacity int32
+	Count    int32
+	FPRate   float64
+	Next     *BloomFilter
+}
+
+// Filter files store the size and hash count in 16 byte fields, of
+// which only the first 8 bytes hold the value. The spare bytes of the
+// size field carry the filter's header: the index of its digest
+// algorithm in digestAlgs, the index of its role in filterRoles, its
+// textNormalization flags and whether it holds paths. Filters written
+// before the header existed have zeroes there, so they load as MD5
+// known-good filters of raw file contents.
+var digestAlgs = []string{"md5", "sha1", "sha256", "sha512"}
+var filterRoles = []string{"known-good", "known-bad", "informational"}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return 0
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // Add adds an element to the filter.
 func (bf *BloomFilter) Add(element string) {
+	if bf.Sidecar != nil {
+		bf.Sidecar.add(element, bf.Digest)
+	}
+	if bf.Next != nil {
+		bf.Next.Add(element)
+		return
+	}
+	if bf.Capacity > 0 && bf.Count >= bf.Capacity {
+		fpRate := bf.FPRate / 2
+		capacity := bf.Capacity * 2
+		if max := maxSliceItems(fpRate); capacity > max || capacity < 0 {
+			capacity = max
+		}
+		next := NewBloomFilter(capacity, fpRate, bf.Fs)
+		next.Digest = bf.Digest
+		next.Role = bf.Role
+		next.Normalize = bf.Normalize
+		next.Paths = bf.Paths
+		next.Capacity = capacity
+		next.FPRate = fpRate
+		bf.Next = &next
+		next.Add(element)
+		return
+	}
+	bf.Count++
 	for seed := int32(0); seed < bf.HashCount; seed++ {
 		key := []byte(element)
 		hash := binary.LittleEndian.Uint32(
@@ -96,13 +209,67 @@ func (bf *BloomFilter) Lookup(element string) bool {
 		)
 		result := int32(hash) % bf.Size
 		if bf.Filter.GetBit(int32(result)) == false {
+			if bf.Next != nil {
+				return bf.Next.Lookup(element)
+			}
 			return false
 		}
 	}
 	return true
 }
 
-// Save saves the filter's current state to a file.
+// AddNew adds an element unless the filter already seems to hold it,
+// and reports whether it was added. An element that is only a false
+// positive is still recorded in the sidecar, which has to hold every
+// element.
+func (bf *BloomFilter) AddNew(element string) bool {
+	if !bf.Lookup(element) {
+		bf.Add(element)
+		return true
+	}
+	if bf.Sidecar != nil {
+		bf.Sidecar.add(element, bf.Digest)
+	}
+	return false
+}
+
+// Contains reports whether the filter holds an element, confirming a
+// match in the sidecar when there is one.
+func (bf *BloomFilter) Contains(element string) bool {
+	return bf.Lookup(element) && (bf.Sidecar == nil || bf.Sidecar.Contains(element, bf.Digest))
+}
+
+// EstimatedFPRate estimates the filter's false positive rate from how
+// many of its bits are set, which works whether or not the number of
+// elements added is known. A lookup in a scalable filter is a false
+// positive if it is one in any slice.
+func (bf *BloomFilter) EstimatedFPRate() float64 {
+	set := 0
+	for _, b := range bf.Filter.Bitfield {
+		set += bits.OnesCount8(b)
+	}
+	rate := 0.0
+	if bf.Size > 0 {
+		rate = math.Pow(float64(set)/float64(bf.Size), float64(bf.HashCount))
+	}
+	if bf.Next != nil {
+		rate = 1 - (1-rate)*(1-bf.Next.EstimatedFPRate())
+	}
+	return rate
+}
+
+// TotalSize returns the size in bits of the filter and any slices
+// chained to it.
+func (bf *BloomFilter) TotalSize() int32 {
+	size := bf.Size
+	if bf.Next != nil {
+		size += bf.Next.TotalSize()
+	}
+	return size
+}
+
+// Save saves the filter's current state to a file. The slices of a
+// scalable filter are written one after another.
 func (bf *BloomFilter) Save(path string) {
 	f, err := bf.Fs.Create(path)
 	if err != nil {
@@ -110,18 +277,47 @@ func (bf *BloomFilter) Save(path string) {
 	}
 	defer f.Close()
 
+	for slice := bf; slice != nil; slice = slice.Next {
+		slice.write(f)
+	}
+	if bf.Sidecar != nil {
+		if err := bf.Sidecar.Save(path+sidecarSuffix, bf.Fs); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// LoadSidecar reads the sidecar saved next to the filter file at path,
+// so that Contains confirms matches.
+func (bf *BloomFilter) LoadSidecar(path string) error {
+	sidecar, err := loadSidecar(path+sidecarSuffix, bf.Digest, bf.Fs)
+	if err != nil {
+		return err
+	}
+	bf.Sidecar = sidecar
+	return nil
+}
+
+func (bf *BloomFilter) write(w io.Writer) {
 	size := make([]byte, 16)
 	binary.LittleEndian.PutUint64(size, uint64(bf.Size))
-	f.Write(size)
+	size[8] = byte(indexOf(digestAlgs, bf.Digest))
+	size[9] = byte(indexOf(filterRoles, bf.Role))
+	size[10] = byte(bf.Normalize)
+	if bf.Paths {
+		size[11] = 1
+	}
+	w.Write(size)
 
 	hashCount := make([]byte, 16)
 	binary.LittleEndian.PutUint64(hashCount, uint64(bf.HashCount))
-	f.Write(hashCount)
+	w.Write(hashCount)
 
-	f.Write(bf.Filter.Bitfield)
+	w.Write(bf.Filter.Bitfield)
 }
 
-// Load loads a saved filter.
+// Load loads a saved filter, including any further slices of a
+// scalable filter.
 func (bf *BloomFilter) Load(path string) {
 	f, err := bf.Fs.Open(path)
 	if err != nil {
@@ -129,102 +325,124 @@ func (bf *BloomFilter) Load(path string) {
 	}
 	defer f.Close()
 
+	if err := bf.read(f); err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	bf.Name = filepath.Base(path)
+	bf.Next = nil
+	for tail := bf; ; tail = tail.Next {
+		next := BloomFilter{Name: bf.Name, Fs: bf.Fs}
+		err := next.read(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		tail.Next = &next
+	}
+}
+
+// read reads one slice of a saved filter. It returns io.EOF if there
+// are no more slices.
+func (bf *BloomFilter) read(r io.Reader) error {
 	sizeBytes := make([]byte, 16)
-	_, err = f.Read(sizeBytes)
-	if err != nil {
-		log.Fatal(err)
+	if _, err := io.ReadFull(r, sizeBytes); err != nil {
+		return err
+	}
+	// Anything else in a filter directory, such as a sidecar, would
+	// otherwise be read as a filter of nonsense size.
+	size := binary.LittleEndian.Uint64(sizeBytes[:8])
+	if size == 0 || size > math.MaxInt32 {
+		return fmt.Errorf("not a filter: invalid size %d", size)
+	}
+	if int(sizeBytes[8]) >= len(digestAlgs) {
+		return fmt.Errorf("not a filter: unknown digest algorithm %d", sizeBytes[8])
+	}
+	if int(sizeBytes[9]) >= len(filterRoles) {
+		return fmt.Errorf("not a filter: unknown role %d", sizeBytes[9])
 	}
-	bf.Size = int32(binary.LittleEndian.Uint64(sizeBytes))
+	bf.Size = int32(size)
+	bf.Digest = digestAlgs[sizeBytes[8]]
+	bf.Role = filterRoles[sizeBytes[9]]
+	bf.Normalize = textNormalization(sizeBytes[10])
+	bf.Paths = sizeBytes[11]&1 != 0
 	bf.ByteSize = byteSize(bf.Size)
 	bf.ByteSizeHuman = byteSizeHuman(bf.Size)
 
 	hcBytes := make([]byte, 16)
-	_, err = f.Read(hcBytes)
-	if err != nil {
-		log.Fatal(err)
+	if _, err := io.ReadFull(r, hcBytes); err != nil {
+		return unexpectedEOF(err)
+	}
+	hashCount := binary.LittleEndian.Uint64(hcBytes[:8])
+	if hashCount > size {
+		return fmt.Errorf("not a filter: invalid hash count %d", hashCount)
 	}
-	bf.HashCount = int32(binary.LittleEndian.Uint64(hcBytes))
+	bf.HashCount = int32(hashCount)
 
 	bitfield := make([]byte, bf.ByteSize)
-	_, err = f.Read(bitfield)
-	if err != nil {
-		log.Fatal(err)
+	if _, err := io.ReadFull(r, bitfield); err != nil {
+		return unexpectedEOF(err)
 	}
 	bf.Filter.Size = bf.Size
 	bf.Filter.Bitfield = bitfield
+	return nil
 }
 
-// CalculateHashes calculates MD5 hashes of all files within a
-// directory, adding them to a Bloom filter.
-func (bf *BloomFilter) CalculateHashes(path string) {
-	info, err := bf.Fs.Stat(path)
-	if err != nil {
-		if !(os.IsPermission(err)) {
-			log.Fatal(err)
-		}
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
-	if info.Mode().IsRegular() {
-		digest := md5File(path, bf.Fs)
-		if digest != "" {
-			fmt.Printf("  %s    %s\n", path, digest)
-		}
-		return
+	return err
+}
+
+// scanner returns the Scanner that CalculateHashes and LookupHashes
+// walk files with.
+func (bf *BloomFilter) scanner() *Scanner {
+	if bf.Scanner != nil {
+		return bf.Scanner
 	}
-	err = afero.Walk(bf.Fs, path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Printf("Error accessing path %q calculating hashes: %v\n", path, err)
-			return err
-		}
-		// We only care about files.
-		if info.Mode().IsRegular() {
-			digest := md5File(path, bf.Fs)
-			if digest != "" {
-				fmt.Printf("  %s    %s\n", path, digest)
-				bf.Add(digest)
-			} else {
-				fmt.Print("Permission Denied\n")
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
+	return NewScanner(bf.Fs)
+}
+
+// pathKey is the element a path-aware filter holds for a file's path,
+// given relative to the root of the scan.
+func pathKey(rel string) string {
+	return "path:" + filepath.ToSlash(rel)
+}
+
+// pathDigestKey is the element a path-aware filter holds for a file's
+// path along with its digest.
+func pathDigestKey(rel, digest string) string {
+	return pathKey(rel) + "\x00" + digest
+}
+
+// setRole gives the filter and every slice chained to it a role.
+func (bf *BloomFilter) setRole(role string) {
+	for slice := bf; slice != nil; slice = slice.Next {
+		slice.Role = role
 	}
 }
 
+// set returns a FilterSet of just this filter.
+func (bf *BloomFilter) set() *FilterSet {
+	return &FilterSet{
+		Filters: []*BloomFilter{bf},
+		Scanner: bf.scanner(),
+		Cache:   bf.Cache,
+		Spec:    bf.Spec,
+	}
+}
+
+// CalculateHashes calculates hashes of all files within a directory
+// using the filter's digest algorithm, adding them to a Bloom filter.
+func (bf *BloomFilter) CalculateHashes(path string) {
+	bf.set().CalculateHashes(path)
+}
+
 // LookupHashes determines if files within a directory have
-// hashes within the Bloom filter.
+// hashes within the Bloom filter. When given a single file, only
+// notable results are reported.
 func (bf *BloomFilter) LookupHashes(path string) {
-	info, err := bf.Fs.Stat(path)
-	if err != nil {
-		if !(os.IsPermission(err)) {
-			log.Fatal(err)
-		}
-	}
-	if info.Mode().IsRegular() {
-		digest := md5File(path, bf.Fs)
-		if digest != "" && !(bf.Lookup(digest)) {
-			fmt.Printf("%s is not in filter\n", path)
-		}
-		return
-	}
-	err = afero.Walk(bf.Fs, path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Printf("Error accessing path %q looking up hashes: %v\n", path, err)
-			return err
-		}
-		// We only care about files.
-		if info.Mode().IsRegular() {
-			digest := md5File(path, bf.Fs)
-			if !(bf.Lookup(digest)) {
-				fmt.Printf("%s is not in filter\n", path)
-			} else {
-				fmt.Printf("%s is in filter\n", path)
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
+	bf.set().LookupHashes(path)
 }
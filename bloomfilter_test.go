@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
 	"log"
 	"math"
+	"strings"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -160,6 +165,95 @@ func TestSaveAndLoad(t *testing.T) {
 	}
 }
 
+func TestSaveAndLoadHeader(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+	fakePath := fakeDir + "test_filter"
+
+	bloomFilter := NewBloomFilter(3, 0.01, fs)
+	bloomFilter.Digest = "sha256"
+	bloomFilter.Role = "known-bad"
+	bloomFilter.Save(fakePath)
+
+	newBloomFilter := NewBloomFilter(5, 0.02, fs)
+	newBloomFilter.Load(fakePath)
+	if newBloomFilter.Digest != "sha256" {
+		t.Errorf(
+			"New BloomFilter: Digest after load: expected: %s actual: %s",
+			"sha256",
+			newBloomFilter.Digest,
+		)
+	}
+	if newBloomFilter.Role != "known-bad" {
+		t.Errorf(
+			"New BloomFilter: Role after load: expected: %s actual: %s",
+			"known-bad",
+			newBloomFilter.Role,
+		)
+	}
+	if newBloomFilter.Name != "test_filter" {
+		t.Errorf(
+			"New BloomFilter: Name after load: expected: %s actual: %s",
+			"test_filter",
+			newBloomFilter.Name,
+		)
+	}
+}
+
+func TestScalableBloomFilter(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+	fakePath := fakeDir + "test_filter"
+
+	var items int32 = 10
+	bloomFilter := NewScalableBloomFilter(items, 0.01, fs)
+	var elements []string
+	for i := 0; i < 100; i++ {
+		elements = append(elements, fmt.Sprintf("element%d", i))
+	}
+	for _, element := range elements {
+		bloomFilter.Add(element)
+	}
+	if bloomFilter.Next == nil {
+		t.Errorf("BloomFilter: Scalable: expected more slices after %d elements", len(elements))
+	}
+	bloomFilter.Save(fakePath)
+
+	newBloomFilter := NewBloomFilter(1, 0.01, fs)
+	newBloomFilter.Load(fakePath)
+	if newBloomFilter.TotalSize() != bloomFilter.TotalSize() {
+		t.Errorf(
+			"BloomFilter: Scalable: TotalSize after load: expected: %d actual: %d",
+			bloomFilter.TotalSize(),
+			newBloomFilter.TotalSize(),
+		)
+	}
+	for _, element := range elements {
+		if !newBloomFilter.Lookup(element) {
+			t.Errorf("BloomFilter: Scalable: expected to find: %s", element)
+		}
+	}
+}
+
+func TestScalableBloomFilterLargeEstimate(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	var items int32 = 200000000
+	fpRate := 0.005
+	bloomFilter := NewScalableBloomFilter(items, fpRate, fs)
+	if max := maxSliceItems(fpRate / 2); bloomFilter.Capacity != max {
+		t.Errorf("BloomFilter: NewScalable: Capacity: expected: %d actual: %d", max, bloomFilter.Capacity)
+	}
+	if bloomFilter.Size <= 0 || bloomFilter.HashCount <= 0 {
+		t.Fatalf("BloomFilter: NewScalable: expected a positive size and hash count actual: %d, %d", bloomFilter.Size, bloomFilter.HashCount)
+	}
+	bloomFilter.Add("element")
+	if !bloomFilter.Lookup("element") {
+		t.Errorf("BloomFilter: NewScalable: expected to find: element")
+	}
+}
+
 func TestCalculateAndLookupHashes(t *testing.T) {
 	var items int32 = 1
 	var fpRate = 0.01
@@ -242,3 +336,56 @@ func TestCalculateAndLookupHashes(t *testing.T) {
 	fs.Chmod(filePath7, 0111)
 	bloomFilter.LookupHashes(filePath7)
 }
+
+func TestEstimatedFPRate(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	bloomFilter := NewBloomFilter(1000, 0.01, fs)
+	if rate := bloomFilter.EstimatedFPRate(); rate != 0 {
+		t.Errorf("BloomFilter: EstimatedFPRate: expected: 0 actual: %v", rate)
+	}
+	for i := 0; i < 1000; i++ {
+		bloomFilter.Add(md5Hex(string(rune(i))))
+	}
+	if rate := bloomFilter.EstimatedFPRate(); rate < 0.005 || rate > 0.02 {
+		t.Errorf("BloomFilter: EstimatedFPRate: expected: about 0.01 actual: %v", rate)
+	}
+}
+
+func TestReadRejectsNonFilters(t *testing.T) {
+	valid := func() []byte {
+		var buf bytes.Buffer
+		bloomFilter := NewBloomFilter(10, 0.01, afero.NewMemMapFs())
+		bloomFilter.write(&buf)
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name   string
+		modify func([]byte)
+	}{
+		{"zero size", func(b []byte) { copy(b[:8], make([]byte, 8)) }},
+		{"negative size", func(b []byte) { binary.LittleEndian.PutUint32(b[:4], 0x80000000) }},
+		{"oversized size", func(b []byte) { b[4] = 1 }},
+		{"unknown digest", func(b []byte) { b[8] = byte(len(digestAlgs)) }},
+		{"unknown role", func(b []byte) { b[9] = 0xff }},
+		{"hash count above size", func(b []byte) { b[20] = 1 }},
+	}
+	for _, test := range tests {
+		content := valid()
+		test.modify(content)
+		var bloomFilter BloomFilter
+		if err := bloomFilter.read(bytes.NewReader(content)); err == nil {
+			t.Errorf("BloomFilter: read: %s: expected an error", test.name)
+		}
+	}
+
+	// A sidecar of md5 digests isn't a filter either.
+	sidecar := []byte(strings.Repeat("\xff", 32*md5.Size))
+	var bloomFilter BloomFilter
+	if err := bloomFilter.read(bytes.NewReader(sidecar)); err == nil {
+		t.Error("BloomFilter: read: sidecar: expected an error")
+	}
+	bloomFilter = BloomFilter{}
+	if err := bloomFilter.read(bytes.NewReader(valid())); err != nil {
+		t.Errorf("BloomFilter: read: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Verdicts given only by check: to digests of a type none of the
+// filters hold, which can't be looked up, and to lines with no digest.
+const (
+	verdictUnchecked = "unchecked"
+	verdictInvalid   = "invalid"
+)
+
+// hashVerdict is the verdict on a digest checked against the filters,
+// as printed by check --json.
+type hashVerdict struct {
+	// Input is the argument or line the digest was read from.
+	Input   string   `json:"input"`
+	Digest  string   `json:"digest,omitempty"`
+	Alg     string   `json:"alg,omitempty"`
+	Verdict string   `json:"verdict"`
+	Matched []string `json:"matched,omitempty"`
+	Noted   []string `json:"noted,omitempty"`
+	// Error says why no digest was read from Input.
+	Error string `json:"error,omitempty"`
+}
+
+// Check gives the verdict on a digest without the file it came from,
+// such as one from an alert. Only filters holding digests of its type
+// are consulted, as a lookup in any other could only be a false
+// positive. Without a path, path-aware filters can't tell a modified
+// file from an unknown one.
+func (s *FilterSet) Check(digest string) hashVerdict {
+	result := hashVerdict{Input: digest, Digest: digest, Alg: digestType(digest)}
+	subset := &FilterSet{}
+	digests := make(map[digestKind]string)
+	for _, bf := range s.Filters {
+		if bf.Digest == result.Alg {
+			subset.Filters = append(subset.Filters, bf)
+			digests[digestKind{bf.Digest, bf.Normalize}] = digest
+		}
+	}
+	if len(subset.Filters) == 0 {
+		result.Verdict = verdictUnchecked
+		return result
+	}
+	result.Verdict, result.Matched, result.Noted = subset.verdict("", digests)
+	if result.Verdict == verdictModified {
+		result.Verdict, result.Matched = verdictUnknown, nil
+	}
+	return result
+}
+
+// hashChecker checks digests read from arguments or hash lists and
+// prints a verdict on each, as text or as JSON lines.
+type hashChecker struct {
+	Set *FilterSet
+	// Column is the CSV column holding digests, as for parseHashLine.
+	Column int
+	JSON   bool
+	// Verdicts counts the digests given each verdict.
+	Verdicts map[string]int
+}
+
+// CheckLine prints the verdict on the digest in a line, which may be in
+// any of the formats parseHashLine reads. Blank lines and # comments
+// are skipped.
+func (c *hashChecker) CheckLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	var result hashVerdict
+	if digest := parseHashLine(line, c.Column); digest != "" {
+		result = c.Set.Check(digest)
+	} else {
+		result.Verdict = verdictInvalid
+		result.Error = "no digest found"
+	}
+	result.Input = line
+	if c.Verdicts == nil {
+		c.Verdicts = make(map[string]int)
+	}
+	c.Verdicts[result.Verdict]++
+
+	if c.JSON {
+		encoded, _ := json.Marshal(result)
+		fmt.Printf("%s\n", encoded)
+		return
+	}
+	var reasons []string
+	if len(result.Matched) > 0 {
+		reasons = append(reasons, "matched "+strings.Join(result.Matched, ", "))
+	}
+	if len(result.Noted) > 0 {
+		reasons = append(reasons, "noted in "+strings.Join(result.Noted, ", "))
+	}
+	suffix := ""
+	if len(reasons) > 0 {
+		suffix = fmt.Sprintf(" (%s)", strings.Join(reasons, "; "))
+	}
+	switch result.Verdict {
+	case verdictInvalid:
+		fmt.Printf("[-] No digest found in: %s\n", line)
+	case verdictUnchecked:
+		fmt.Printf("[-] %s is %s (no %s filters)\n", result.Digest, result.Verdict, result.Alg)
+	case verdictKnownBad:
+		fmt.Printf("[!] %s is %s%s\n", result.Digest, result.Verdict, suffix)
+	default:
+		fmt.Printf("%s is %s%s\n", result.Digest, result.Verdict, suffix)
+	}
+}
+
+// Read checks every line of a hash list.
+func (c *hashChecker) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		c.CheckLine(scanner.Text())
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestFilterSetCheck(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	good := NewBloomFilter(10, 0.01, fs)
+	good.Name = "good"
+	bad := NewBloomFilter(10, 0.01, fs)
+	bad.Name = "bad"
+	bad.Role = "known-bad"
+	sha256Filter := NewBloomFilter(10, 0.01, fs)
+	sha256Filter.Name = "sha256"
+	sha256Filter.Digest = "sha256"
+
+	goodDigest, _ := hashReader(strings.NewReader("good"), "md5")
+	badDigest, _ := hashReader(strings.NewReader("bad"), "md5")
+	unknownDigest, _ := hashReader(strings.NewReader("unknown"), "md5")
+	sha1Digest, _ := hashReader(strings.NewReader("good"), "sha1")
+	good.Add(goodDigest)
+	bad.Add(badDigest)
+	set := &FilterSet{Filters: []*BloomFilter{&good, &bad, &sha256Filter}}
+
+	tests := []struct {
+		digest  string
+		verdict string
+		matched string
+	}{
+		{goodDigest, verdictKnownGood, "good"},
+		{badDigest, verdictKnownBad, "bad"},
+		{unknownDigest, verdictUnknown, ""},
+		{sha1Digest, verdictUnchecked, ""},
+	}
+	for _, test := range tests {
+		result := set.Check(test.digest)
+		if result.Verdict != test.verdict {
+			t.Errorf("FilterSet: Check: %s: expected: %s actual: %s", test.digest, test.verdict, result.Verdict)
+		}
+		if matched := strings.Join(result.Matched, ","); matched != test.matched {
+			t.Errorf("FilterSet: Check: %s: expected match: %q actual: %q", test.digest, test.matched, matched)
+		}
+	}
+}
+
+func TestHashCheckerRead(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	good := NewBloomFilter(10, 0.01, fs)
+	digest, _ := hashReader(strings.NewReader("good"), "md5")
+	good.Add(digest)
+	checker := hashChecker{Set: &FilterSet{Filters: []*BloomFilter{&good}}, JSON: true}
+
+	list := "# alert export\n" +
+		digest + "  /tmp/good\n" +
+		"MD5 (/tmp/other) = " + strings.ToUpper(digest) + "\n" +
+		"ffffffffffffffffffffffffffffffff\n" +
+		"\n" +
+		"not a digest\n"
+	if err := checker.Read(strings.NewReader(list)); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{verdictKnownGood: 2, verdictUnknown: 1, verdictInvalid: 1}
+	for verdict, count := range expected {
+		if checker.Verdicts[verdict] != count {
+			t.Errorf("hashChecker: Read: %s: expected: %d actual: %d", verdict, count, checker.Verdicts[verdict])
+		}
+	}
+}
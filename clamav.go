@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// clamavSignature is a hash signature from a ClamAV database.
+type clamavSignature struct {
+	Digest string
+	Size   string
+	Name   string
+}
+
+// clamavAlgs are the digest algorithms ClamAV hash signatures use.
+var clamavAlgs = []string{"md5", "sha1", "sha256"}
+
+// clamavDatabaseAlgs returns the digest algorithms the signatures in the
+// database at path can use, going by its extension: MD5 databases
+// (.hdb, .mdb) hold MD5 digests and SHA databases (.hsb, .msb) SHA1 or
+// SHA256 ones, as do their PUA variants ending in u.
+func clamavDatabaseAlgs(path string) []string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if len(ext) == 4 && (ext[1] == 'h' || ext[1] == 'm') {
+		switch ext[2] {
+		case 'd':
+			return []string{"md5"}
+		case 's':
+			return []string{"sha1", "sha256"}
+		}
+	}
+	return clamavAlgs
+}
+
+// clamavSignatures reads the hash signatures in a ClamAV database.
+// File hash databases (.hdb, .hsb and their .hdu/.hsu PUA variants)
+// have hash:size:name lines. PE section hash databases (.mdb, .msb,
+// .mdu, .msu) have size:hash:name lines; their digests cover a single
+// PE section rather than a whole file, so they only match files that
+// consist of that section alone. Signatures whose digest isn't one the
+// database can hold are skipped and counted.
+func clamavSignatures(path string, fs afero.Fs) []clamavSignature {
+	f, err := openInput(path, fs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	sizeFirst := strings.HasPrefix(strings.ToLower(filepath.Ext(path)), ".m")
+	algs := clamavDatabaseAlgs(path)
+	var signatures []clamavSignature
+	invalid := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			invalid++
+			continue
+		}
+		signature := clamavSignature{
+			Digest: strings.ToLower(fields[0]),
+			Size:   fields[1],
+			Name:   fields[2],
+		}
+		if sizeFirst {
+			signature.Digest, signature.Size = strings.ToLower(fields[1]), fields[0]
+		}
+		if !contains(algs, digestType(signature.Digest)) {
+			invalid++
+			continue
+		}
+		signatures = append(signatures, signature)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if invalid > 0 {
+		fmt.Printf("    Skipped %d lines without a valid %s digest.\n", invalid, strings.Join(algs, " or "))
+	}
+	return signatures
+}
+
+// clamavDigestAlg returns the digest algorithm used by most of the
+// signatures, keeping the first of clamavAlgs on a tie. There is none
+// to choose when no signature was valid.
+func clamavDigestAlg(signatures []clamavSignature) (string, error) {
+	counts := make(map[string]int)
+	for _, signature := range signatures {
+		counts[digestType(signature.Digest)]++
+	}
+	best := ""
+	for _, alg := range clamavAlgs {
+		if counts[alg] > counts[best] {
+			best = alg
+		}
+	}
+	if best == "" {
+		return "", errors.New("no valid hash signatures found")
+	}
+	return best, nil
+}
+
+// clamavDigests returns the distinct digests of the signatures that
+// use the given algorithm, along with how many signatures were skipped
+// because they use another one.
+func clamavDigests(signatures []clamavSignature, alg string) ([]string, int) {
+	seen := make(map[string]bool)
+	skipped := 0
+	for _, signature := range signatures {
+		if digestType(signature.Digest) != alg {
+			skipped++
+			continue
+		}
+		seen[signature.Digest] = true
+	}
+	var digests []string
+	for digest := range seen {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	return digests, skipped
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+const clamavHsb = `# comment
+2aae6c35c94fcfb415dbe95f408b9ce91ee846ed:11:Win.Test.EICAR_HDB-1
+A591A6D40BF420404A011733CFB7B190D62C65BF0BCDA32B57B277D9AD9F146E:*:Php.Malware.Agent-1:73
+b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9:11:Php.Malware.Agent-2:73
+not-a-hash:1:Broken-1
+`
+
+const clamavHdb = `44d88612fea8a8f36de82e1278abb02f:68:Win.Test.EICAR_HDB-1
+`
+
+const clamavMdb = `45056:3ea7d00dedd30bcdf46191358c36ffa4:Win.Trojan.Section-1
+`
+
+func TestClamavSignatures(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	afero.WriteFile(fs, "/tmp/main.hsb", []byte(clamavHsb), 0644)
+	afero.WriteFile(fs, "/tmp/main.mdb", []byte(clamavMdb), 0644)
+
+	signatures := clamavSignatures("/tmp/main.hsb", fs)
+	if len(signatures) != 3 {
+		t.Fatalf(
+			"clamavSignatures: hsb: expected: %d actual: %d",
+			3,
+			len(signatures),
+		)
+	}
+	if signatures[1].Name != "Php.Malware.Agent-1" || signatures[1].Size != "*" {
+		t.Errorf("clamavSignatures: hsb: unexpected signature: %v", signatures[1])
+	}
+
+	alg, err := clamavDigestAlg(signatures)
+	if alg != "sha256" || err != nil {
+		t.Errorf("clamavDigestAlg: expected: sha256 actual: %s %v", alg, err)
+	}
+	if _, err := clamavDigestAlg(nil); err == nil {
+		t.Errorf("clamavDigestAlg: expected an error without signatures")
+	}
+	tie := []clamavSignature{signatures[0], signatures[1], {Digest: "44d88612fea8a8f36de82e1278abb02f"}}
+	if alg, _ := clamavDigestAlg(tie); alg != "md5" {
+		t.Errorf("clamavDigestAlg: tie: expected: md5 actual: %s", alg)
+	}
+	digests, skipped := clamavDigests(signatures, alg)
+	if len(digests) != 2 || skipped != 1 {
+		t.Errorf(
+			"clamavDigests: expected: 2 digests 1 skipped actual: %d digests %d skipped",
+			len(digests),
+			skipped,
+		)
+	}
+
+	signatures = clamavSignatures("/tmp/main.mdb", fs)
+	if len(signatures) != 1 || signatures[0].Digest != "3ea7d00dedd30bcdf46191358c36ffa4" {
+		t.Errorf("clamavSignatures: mdb: unexpected signatures: %v", signatures)
+	}
+
+	// MD5 databases can't hold the SHA digests of a .hsb.
+	afero.WriteFile(fs, "/tmp/main.hdb", []byte(clamavHsb+clamavHdb), 0644)
+	signatures = clamavSignatures("/tmp/main.hdb", fs)
+	if len(signatures) != 1 || signatures[0].Digest != "44d88612fea8a8f36de82e1278abb02f" {
+		t.Errorf("clamavSignatures: hdb: expected only the md5 signature actual: %v", signatures)
+	}
+	afero.WriteFile(fs, "/tmp/mixed.hsb", []byte(clamavHdb+clamavHsb), 0644)
+	if signatures = clamavSignatures("/tmp/mixed.hsb", fs); len(signatures) != 3 {
+		t.Errorf("clamavSignatures: hsb: expected: 3 sha signatures actual: %v", signatures)
+	}
+}
+
+func TestImportClamav(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fs.MkdirAll("/tmp/filters/", 0755)
+	afero.WriteFile(fs, "/tmp/main.hsb", []byte(clamavHsb), 0644)
+
+	args := []string{"mdd", "import", "clamav", "--digest", "sha1", "/tmp/filters/clamav", "/tmp/main.hsb"}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Import()
+
+	bloomFilter := NewBloomFilter(1, 0.01, fs)
+	bloomFilter.Load("/tmp/filters/clamav")
+	if bloomFilter.Digest != "sha1" || bloomFilter.Role != "known-bad" {
+		t.Errorf(
+			"Import: clamav: expected: sha1 known-bad actual: %s %s",
+			bloomFilter.Digest,
+			bloomFilter.Role,
+		)
+	}
+	digest := "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"
+	if !bloomFilter.Lookup(digest) {
+		t.Errorf("Import: clamav: expected to find: %s", digest)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// typeHeaderSize is how much of a file is read to detect its type.
+// PHP hidden after an image header is usually within the first few
+// kilobytes.
+const typeHeaderSize = 8192
+
+// magicTypes maps file signatures to types, checked in order.
+var magicTypes = []struct {
+	magic []byte
+	kind  string
+}{
+	{[]byte("\x7fELF"), "elf"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xce}, "macho"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xcf}, "macho"},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, "macho"},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, "macho"},
+	{[]byte{0xff, 0xd8, 0xff}, "jpeg"},
+	{[]byte("\x89PNG\r\n\x1a\n"), "png"},
+	{[]byte("GIF87a"), "gif"},
+	{[]byte("GIF89a"), "gif"},
+	{[]byte("%PDF-"), "pdf"},
+	{[]byte("\x00asm"), "wasm"},
+}
+
+// interpreterTypes maps the interpreters named in shebang lines to
+// types.
+var interpreterTypes = map[string]string{
+	"sh":      "shell",
+	"bash":    "shell",
+	"dash":    "shell",
+	"ksh":     "shell",
+	"zsh":     "shell",
+	"ash":     "shell",
+	"busybox": "shell",
+	"python":  "python",
+	"perl":    "perl",
+	"ruby":    "ruby",
+	"node":    "javascript",
+	"nodejs":  "javascript",
+	"php":     "php",
+	"lua":     "lua",
+}
+
+// executableTypes are the types of content a web server or shell might
+// run.
+var executableTypes = []string{
+	"elf",
+	"pe",
+	"macho",
+	"java",
+	"wasm",
+	"php",
+	"javascript",
+	"shell",
+	"python",
+	"perl",
+	"ruby",
+	"lua",
+}
+
+// extensionTypes maps file extensions to the type of content they
+// should hold.
+var extensionTypes = map[string]string{
+	"jpg":   "jpeg",
+	"jpeg":  "jpeg",
+	"png":   "png",
+	"gif":   "gif",
+	"pdf":   "pdf",
+	"ico":   "ico",
+	"svg":   "svg",
+	"txt":   "text",
+	"css":   "text",
+	"csv":   "text",
+	"html":  "html",
+	"htm":   "html",
+	"php":   "php",
+	"phtml": "php",
+	"inc":   "php",
+	"js":    "javascript",
+	"mjs":   "javascript",
+	"sh":    "shell",
+	"bash":  "shell",
+	"py":    "python",
+	"pl":    "perl",
+	"rb":    "ruby",
+	"class": "java",
+	"so":    "elf",
+	"exe":   "pe",
+	"dll":   "pe",
+	"wasm":  "wasm",
+}
+
+// typeAliases map common short names to the types detectType returns.
+var typeAliases = map[string]string{
+	"js":  "javascript",
+	"sh":  "shell",
+	"py":  "python",
+	"jpg": "jpeg",
+	"exe": "pe",
+}
+
+// normalizeType turns a type named on the command line into one
+// detectType returns.
+func normalizeType(kind string) string {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if alias, ok := typeAliases[kind]; ok {
+		return alias
+	}
+	return kind
+}
+
+// jsHints are snippets that suggest text is JavaScript. Any one of
+// them turns up in prose, and in other languages, often enough that two
+// are needed, one of them from jsSyntax.
+var jsHints = []string{
+	"function",
+	"var ",
+	"let ",
+	"const ",
+	"return ",
+	"eval(",
+}
+
+// jsSyntax are snippets that are rarely found outside JavaScript.
+var jsSyntax = []string{
+	"=>",
+	"});",
+	"document.",
+	"window.",
+	"require(",
+	"module.exports",
+	"addeventlistener(",
+	"console.log(",
+	"'use strict'",
+	"\"use strict\"",
+}
+
+// jsFunction matches a line declaring a function, as JavaScript does.
+var jsFunction = regexp.MustCompile(`^(async\s+)?function\s*[\w$]*\s*\(`)
+
+// imageTypes are the types of image PHP is hidden in.
+var imageTypes = []string{"jpeg", "png", "gif"}
+
+// binaryTypes are the types of file that never hold text, so a PHP tag
+// anywhere in a file named as one is PHP hidden there, whatever comes
+// before it.
+var binaryTypes = []string{"jpeg", "png", "gif", "ico", "pdf"}
+
+// isPHP reports whether text is PHP: it opens with a PHP tag, or is
+// markup holding one. A tag elsewhere is more likely quoted, as in
+// code or documentation about PHP.
+func isPHP(header []byte) bool {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(header, []byte("\xef\xbb\xbf")))
+	if bytes.HasPrefix(trimmed, []byte("<?php")) || bytes.HasPrefix(trimmed, []byte("<?=")) {
+		return true
+	}
+	return bytes.HasPrefix(trimmed, []byte("<")) && hasPHPTag(header)
+}
+
+// hasPHPTag reports whether a PHP tag is anywhere in header.
+func hasPHPTag(header []byte) bool {
+	return bytes.Contains(header, []byte("<?php")) || bytes.Contains(header, []byte("<?="))
+}
+
+// isPE reports whether header starts a Windows executable: an MS-DOS
+// header whose e_lfanew field points at a PE signature.
+func isPE(header []byte) bool {
+	if !bytes.HasPrefix(header, []byte("MZ")) || len(header) < 0x40 {
+		return false
+	}
+	offset := binary.LittleEndian.Uint32(header[0x3c:0x40])
+	return offset >= 0x40 && uint64(offset)+4 <= uint64(len(header)) &&
+		bytes.Equal(header[offset:offset+4], []byte("PE\x00\x00"))
+}
+
+// isJavaScript reports whether lowercased text looks like JavaScript,
+// going by the hints on lines that aren't comments.
+func isJavaScript(lower string) bool {
+	found := make(map[string]bool)
+	strong := false
+	for _, line := range strings.Split(lower, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "//") || strings.HasPrefix(line, "/*") ||
+			strings.HasPrefix(line, "*") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, hint := range jsHints {
+			if strings.Contains(line, hint) {
+				found[hint] = true
+			}
+		}
+		for _, hint := range jsSyntax {
+			if strings.Contains(line, hint) {
+				found[hint] = true
+				strong = true
+			}
+		}
+		if jsFunction.MatchString(line) {
+			found["function declaration"] = true
+			strong = true
+		}
+	}
+	return strong && len(found) >= 2
+}
+
+// detectType guesses what a file holds from its first bytes: a binary
+// format, an image, a script named by its shebang line, PHP, HTML,
+// JavaScript, other text, or "data" for anything else. PHP is looked
+// for anywhere in an image, so PHP appended to a valid image is found.
+func detectType(header []byte) string {
+	if len(header) == 0 {
+		return "empty"
+	}
+	for _, magic := range magicTypes {
+		if bytes.HasPrefix(header, magic.magic) {
+			if hasPHPTag(header) && contains(imageTypes, magic.kind) {
+				return "php"
+			}
+			return magic.kind
+		}
+	}
+	if isPE(header) {
+		return "pe"
+	}
+	if bytes.HasPrefix(header, []byte{0xca, 0xfe, 0xba, 0xbe}) && len(header) >= 8 {
+		// Java classes share their magic with universal Mach-O
+		// binaries, which count architectures where Java has versions.
+		if binary.BigEndian.Uint16(header[6:8]) >= 45 {
+			return "java"
+		}
+		return "macho"
+	}
+	if kind := archiveKind(header); kind != "" {
+		return kind
+	}
+	if bytes.HasPrefix(header, []byte("#!")) {
+		if kind := shebangType(header); kind != "" {
+			return kind
+		}
+	}
+	if bytes.IndexByte(header, 0) != -1 {
+		return "data"
+	}
+	if isPHP(header) {
+		return "php"
+	}
+	lower := strings.ToLower(string(header))
+	trimmed := strings.TrimSpace(lower)
+	switch {
+	case strings.HasPrefix(trimmed, "<!doctype html") || strings.Contains(lower, "<html"):
+		return "html"
+	case strings.HasPrefix(trimmed, "<svg") || strings.HasPrefix(trimmed, "<?xml") && strings.Contains(lower, "<svg"):
+		return "svg"
+	}
+	if isJavaScript(lower) {
+		return "javascript"
+	}
+	return "text"
+}
+
+// detectFileType guesses what the file named name holds from its first
+// bytes, as detectType does. A file named as a binary type is PHP if a
+// PHP tag is anywhere in them, even after text that isn't markup.
+func detectFileType(name string, header []byte) string {
+	kind := detectType(header)
+	if kind == "php" || !hasPHPTag(header) {
+		return kind
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if contains(binaryTypes, extensionTypes[ext]) {
+		return "php"
+	}
+	return kind
+}
+
+// shebangType returns the type of script a shebang line runs, looking
+// past env to the interpreter it starts.
+func shebangType(header []byte) string {
+	line := string(header[2:])
+	if end := strings.IndexByte(line, '\n'); end != -1 {
+		line = line[:end]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" {
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return ""
+		}
+		interpreter = path.Base(fields[0])
+	}
+	// Strip versions, as in python3 or php8.2.
+	interpreter = strings.TrimRight(interpreter, "0123456789.")
+	if kind, ok := interpreterTypes[interpreter]; ok {
+		return kind
+	}
+	return "script"
+}
+
+// isExecutableType reports whether content of type kind could be run.
+func isExecutableType(kind string) bool {
+	return kind == "script" || contains(executableTypes, kind)
+}
+
+// disguisedType returns the type a file's extension says it should
+// hold if the file actually holds executable content of another type,
+// such as PHP in a file named image.jpg. It returns "" otherwise.
+func disguisedType(name, kind string) string {
+	if !isExecutableType(kind) {
+		return ""
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	expected, ok := extensionTypes[ext]
+	if !ok || expected == kind {
+		return ""
+	}
+	// Scripts embedded in pages and text aren't run as they are.
+	if kind == "javascript" && (expected == "html" || expected == "svg" || expected == "text") {
+		return ""
+	}
+	return expected
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestDetectType(t *testing.T) {
+	tests := map[string]string{
+		"":                                        "empty",
+		"\x7fELF\x02\x01\x01":                     "elf",
+		"MZ\x90\x00":                              "data",
+		"\xca\xfe\xba\xbe\x00\x00\x00\x34":        "java",
+		"\xca\xfe\xba\xbe\x00\x00\x00\x02":        "macho",
+		"\xff\xd8\xff\xe0\x00\x10JFIF":            "jpeg",
+		"GIF89a\x01\x00<?php system($_GET[1]);":   "php",
+		"<html><body><?= $x ?></body></html>":     "php",
+		"#!/bin/sh\necho hi\n":                    "shell",
+		"#!/usr/bin/env -S python3 -u\nprint()\n": "python",
+		"#!/usr/local/bin/php8.2\n":               "php",
+		"#!/opt/bin/custom\n":                     "script",
+		"<!DOCTYPE html>\n<html></html>":          "html",
+		"var x = 1;\nfunction f() { return x; }":  "javascript",
+		"$(function() {\n  $('a').hide();\n});":   "javascript",
+		"\xef\xbb\xbf<?php\necho 1;\n":            "php",
+		"echo '<?php';\n":                         "text",
+		"Let me know which function you need.":    "text",
+		"PK\x03\x04":                              "zip",
+		"binary\x00data":                          "data",
+	}
+	for header, expected := range tests {
+		if kind := detectType([]byte(header)); kind != expected {
+			t.Errorf("detectType: %q: expected: %s actual: %s", header, expected, kind)
+		}
+	}
+}
+
+func TestDetectTypePE(t *testing.T) {
+	header := make([]byte, 0x84)
+	copy(header, "MZ")
+	binary.LittleEndian.PutUint32(header[0x3c:], 0x80)
+	copy(header[0x80:], "PE\x00\x00")
+	if kind := detectType(header); kind != "pe" {
+		t.Errorf("detectType: PE: expected: pe actual: %s", kind)
+	}
+	for _, offset := range []uint32{0, 0x3c, 0x82, 0xffffffff} {
+		binary.LittleEndian.PutUint32(header[0x3c:], offset)
+		if kind := detectType(header); kind == "pe" {
+			t.Errorf("detectType: MZ with e_lfanew %#x: expected: data actual: %s", offset, kind)
+		}
+	}
+	if kind := detectType([]byte("MZ is the name of the band.\n")); kind != "text" {
+		t.Errorf("detectType: text starting MZ: expected: text actual: %s", kind)
+	}
+}
+
+func TestDetectTypeSource(t *testing.T) {
+	fixtures := map[string]string{
+		"filetype.go": `package main
+
+import "fmt"
+
+// detect the type of a file, whether a function or
+// an arrow => is found.
+const header = "<?php"
+
+var types = map[string]string{"js": "javascript"}
+
+func main() {
+	fmt.Println(header, types)
+}
+`,
+		"README.md": "# PHP webshells\n\n" +
+			"A webshell is often a single line such as `<?php system($_GET[1]); ?>`.\n" +
+			"Use the const and var keywords to declare a function's state.\n",
+		"notes.txt": "Found <?php eval() in the access log.\n" +
+			"Let me know which function you need, and return the var name.\n",
+	}
+	for name, content := range fixtures {
+		if kind := detectType([]byte(content)); kind != "text" {
+			t.Errorf("detectType: %s: expected: text actual: %s", name, kind)
+		}
+	}
+}
+
+func TestDetectFileType(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"uploads/avatar.jpg", "Created with GIMP\n<?php eval($_POST['x']);", "php"},
+		{"uploads/avatar.JPG", "/* comment */ <?php system($_GET['c']);", "php"},
+		{"uploads/report.pdf", "junk <?php phpinfo();", "php"},
+		{"docs/readme.txt", "Add <?php wp_head(); ?> to your theme.", "text"},
+		{"uploads/avatar.jpg", "Created with GIMP", "text"},
+		{"index.php", "<?php\necho 1;\n", "php"},
+	}
+	for _, test := range tests {
+		if kind := detectFileType(test.name, []byte(test.header)); kind != test.expected {
+			t.Errorf("detectFileType: %s: expected: %s actual: %s", test.name, test.expected, kind)
+		}
+	}
+}
+
+func TestDisguisedType(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		expected string
+	}{
+		{"uploads/image.jpg", "php", "jpeg"},
+		{"archive.zip!photos/cat.PNG", "elf", "png"},
+		{"index.php", "php", ""},
+		{"image.jpg", "jpeg", ""},
+		{"page.html", "javascript", ""},
+		{"README", "shell", ""},
+	}
+	for _, test := range tests {
+		if expected := disguisedType(test.name, test.kind); expected != test.expected {
+			t.Errorf(
+				"disguisedType: %s (%s): expected: %q actual: %q",
+				test.name,
+				test.kind,
+				test.expected,
+				expected,
+			)
+		}
+	}
+}
+
+func TestScanTypes(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/www/uploads/"
+	fs.MkdirAll(fakeDir, 0755)
+	afero.WriteFile(fs, fakeDir+"photo.jpg", []byte("\xff\xd8\xff\xe0 JFIF"), 0644)
+	afero.WriteFile(fs, fakeDir+"image.jpg", []byte("GIF89a<?php eval($_POST['x']);"), 0644)
+	afero.WriteFile(fs, fakeDir+"notes.txt", []byte("notes"), 0644)
+	afero.WriteFile(fs, fakeDir+"run", []byte("#!/bin/bash\nid\n"), 0644)
+
+	tests := []struct {
+		types    []string
+		expected []string
+	}{
+		{nil, []string{"image.jpg:php", "notes.txt:text", "photo.jpg:jpeg", "run:shell"}},
+		{[]string{"php"}, []string{"image.jpg:php"}},
+		{[]string{"executable"}, []string{"image.jpg:php", "run:shell"}},
+	}
+	for _, test := range tests {
+		scanner := NewScanner(fs)
+		scanner.DetectTypes = true
+		scanner.Types = test.types
+		var found []string
+		scanner.Walk(fakeDir, func(f *scannedFile) error {
+			found = append(found, f.Rel+":"+f.Type)
+			return nil
+		})
+		sort.Strings(found)
+		if strings.Join(found, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Scanner: Types %v: expected: %v actual: %v", test.types, test.expected, found)
+		}
+		if count := scanner.Count(fakeDir); int(count) != len(test.expected) {
+			t.Errorf("Scanner: Count with Types %v: expected: %d actual: %d", test.types, len(test.expected), count)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -17,11 +18,16 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/spf13/afero"
 )
 
 type mddConfig struct {
 	HashAlg string `json:"hash_alg"`
 	Repo    string `json:"repo"`
+	// Sets names groups of installed filters to look files up in
+	// together. Members may be filter names or glob patterns.
+	Sets map[string][]string `json:"sets,omitempty"`
 }
 
 type hashAlgorithm struct {
@@ -38,6 +44,9 @@ type filter struct {
 	MD5             string        `json:"md5,omitempty"`
 	SHA1            string        `json:"sha1,omitempty"`
 	SHA256          string        `json:"sha256,omitempty"`
+	// Role is one of filterRoles, overriding the role stored in the
+	// filter file when set.
+	Role string `json:"role,omitempty"`
 }
 
 func getConfig() mddConfig {
@@ -112,6 +121,8 @@ func getHasher(hashAlgorithm string) hash.Hash {
 		hasher = sha1.New()
 	case "sha256":
 		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
 	default:
 		log.Fatalf("Invalid hash algorithm: %s", hashAlgorithm)
 	}
@@ -132,6 +143,54 @@ func filterPath() string {
 	return path
 }
 
+// installedFilters returns the paths of the installed filters whose
+// names match one of patterns, or of every installed filter if no
+// patterns are given, sorted by name.
+func installedFilters(fs afero.Fs, patterns []string) ([]string, error) {
+	infos, err := afero.ReadDir(fs, filterPath())
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, info := range infos {
+		name := info.Name()
+		// Sidecars live next to their filters but aren't filters.
+		if info.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, sidecarSuffix) {
+			continue
+		}
+		match := len(patterns) == 0
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				match = true
+			}
+		}
+		if match {
+			paths = append(paths, filepath.Join(filterPath(), name))
+		}
+	}
+	return paths, nil
+}
+
+// installedRoles returns the roles given to installed filters in
+// installed.json, by filter name.
+func installedRoles(fs afero.Fs) map[string]string {
+	roles := make(map[string]string)
+	content, err := afero.ReadFile(fs, "installed.json")
+	if err != nil {
+		return roles
+	}
+	var installed map[string]filter
+	if err := json.Unmarshal(content, &installed); err != nil {
+		return roles
+	}
+	for name, data := range installed {
+		if contains(filterRoles, data.Role) {
+			roles[name] = data.Role
+		}
+	}
+	return roles
+}
+
 func getInstalled(hashAlg string) map[string]filter {
 	content, readErr := ioutil.ReadFile("installed.json")
 	if readErr != nil {
@@ -180,8 +239,8 @@ func getInstalled(hashAlg string) map[string]filter {
 }
 
 func printFilters(filters map[string]filter) {
-	fmt.Printf("%-20s%-40s%-20s\n", "Filter", "Description", "Last Modified")
-	fmt.Printf("%s\n", strings.Repeat("-", 90))
+	fmt.Printf("%-20s%-15s%-40s%-20s\n", "Filter", "Role", "Description", "Last Modified")
+	fmt.Printf("%s\n", strings.Repeat("-", 105))
 	var keys []string
 	for k := range filters {
 		keys = append(keys, k)
@@ -189,8 +248,9 @@ func printFilters(filters map[string]filter) {
 	sort.Strings(keys)
 	for _, key := range keys {
 		fmt.Printf(
-			"%-20s%-40s%-20s\n",
+			"%-20s%-15s%-40s%-20s\n",
 			key,
+			filters[key].Role,
 			filters[key].Description,
 			filters[key].LastModified,
 		)
@@ -265,6 +325,7 @@ func updateInstalled(target string) {
 		Description:  targetData.Description,
 		LastModified: targetData.LastModified,
 		Hash:         hash,
+		Role:         targetData.Role,
 	}
 	path := filepath.Join(currentDir(), "installed.json")
 	data, marshalErr := json.MarshalIndent(installed, "", "    ")
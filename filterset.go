@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// FilterSet is a group of filters built or checked together. Each file
+// is read once, however many kinds of digest the filters hold.
+type FilterSet struct {
+	Filters []*BloomFilter
+	// Scanner walks the files hashed.
+	Scanner *Scanner
+	// Cache, when set, holds digests of files from earlier scans, used
+	// instead of reading files that haven't changed.
+	Cache *HashCache
+	// Spec, when set, records every file and directory visited by
+	// CalculateHashes.
+	Spec *MtreeSpec
+	// Verdicts counts the files LookupHashes has given each verdict.
+	Verdicts map[string]int
+	// Print0, when set, is written the path of each unknown file,
+	// terminated by a NUL byte, instead of the usual report.
+	Print0 io.Writer
+	// Summary, when set, counts the verdicts on files by directory
+	// instead of reporting each file.
+	Summary *treeSummary
+	// Report, when set, collects the verdicts on files for an HTML
+	// report, alongside the usual output.
+	Report *htmlReport
+	// Sarif, when set, collects unknown, modified and known-bad files as
+	// SARIF results, alongside the usual output.
+	Sarif *sarifLog
+}
+
+// kinds returns the kinds of digest the filters hold.
+func (s *FilterSet) kinds() []digestKind {
+	var kinds []digestKind
+	for _, bf := range s.Filters {
+		kind := digestKind{bf.Digest, bf.Normalize}
+		found := false
+		for _, k := range kinds {
+			found = found || k == kind
+		}
+		if !found {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// digests returns the digests of a scanned file that the filters need.
+// Digests in the cache are used while the file hasn't changed, and the
+// rest are calculated together from a single read of the file.
+func (s *FilterSet) digests(f *scannedFile) (map[digestKind]string, error) {
+	digests := make(map[digestKind]string)
+	var missing []digestKind
+	for _, kind := range s.kinds() {
+		if s.Cache != nil {
+			if digest, ok := s.Cache.Get(f.Info, kind.String()); ok {
+				digests[kind] = digest
+				continue
+			}
+		}
+		missing = append(missing, kind)
+	}
+	if len(missing) == 0 {
+		return digests, nil
+	}
+	calculated, err := hashDigests(f.Reader, missing, f.Type)
+	if err != nil {
+		return nil, err
+	}
+	for kind, digest := range calculated {
+		digests[kind] = digest
+		if s.Cache != nil {
+			s.Cache.Put(f.Info, kind.String(), digest)
+		}
+	}
+	return digests, nil
+}
+
+// digestOf returns the digest of a file a filter holds.
+func digestOf(bf *BloomFilter, digests map[digestKind]string) string {
+	return digests[digestKind{bf.Digest, bf.Normalize}]
+}
+
+// CalculateHashes calculates hashes of all files within a directory,
+// adding each file to every filter using the filter's digest algorithm.
+func (s *FilterSet) CalculateHashes(path string) {
+	scanner := *s.Scanner
+	scanner.Dirs = s.Spec != nil
+	err := scanner.Walk(path, func(f *scannedFile) error {
+		if f.Info.IsDir() {
+			s.Spec.Add(f.Rel, f.Info, nil)
+			return nil
+		}
+		if f.Err != nil {
+			fmt.Fprint(s.Scanner.Out, "Permission Denied\n")
+			return nil
+		}
+		digests, err := s.digests(f)
+		if err != nil {
+			fmt.Fprintf(s.Scanner.Out, "Error reading %s: %v\n", f.Path, err)
+			return nil
+		}
+		var printed []string
+		specDigests := make(map[string]string)
+		for _, bf := range s.Filters {
+			digest := digestOf(bf, digests)
+			if !contains(printed, digest) {
+				printed = append(printed, digest)
+			}
+			if _, ok := specDigests[bf.Digest]; !ok {
+				specDigests[bf.Digest] = digest
+			}
+			// Releases share most of their files, so skip digests we
+			// already have rather than let them fill a scalable filter.
+			bf.AddNew(digest)
+			if rel := s.pathRel(f); bf.Paths && rel != "" {
+				bf.Add(pathKey(rel))
+				bf.Add(pathDigestKey(rel, digest))
+			}
+		}
+		fmt.Fprintf(s.Scanner.Out, "  %s    %s\n", f.Path, strings.Join(printed, " "))
+		if s.Spec != nil {
+			s.Spec.Add(f.Rel, f.Info, specDigests)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Verdicts given to files looked up. A match in a known-bad filter
+// takes precedence over any other.
+const (
+	verdictKnownBad  = "known-bad"
+	verdictKnownGood = "known-good"
+	verdictModified  = "modified"
+	verdictUnknown   = "unknown"
+)
+
+// pathRel returns the path a path-aware filter knows a file by, or ""
+// when the scan started from the file itself or an archive holding it.
+// Its path within the tree it came from isn't known then, only its
+// base name.
+func (s *FilterSet) pathRel(f *scannedFile) string {
+	if !s.Scanner.FromArchive && (f.Path == f.Root || strings.HasPrefix(f.Path, f.Root+"!")) {
+		return ""
+	}
+	return f.Rel
+}
+
+// match reports whether a filter holds a file. A path-aware filter that
+// knows the file's path only holds it with the content it had there,
+// and otherwise modified is set. Files without a path, rel being "",
+// are only matched by content.
+func match(bf *BloomFilter, rel string, digests map[digestKind]string) (found, modified bool) {
+	digest := digestOf(bf, digests)
+	if bf.Paths && rel != "" && bf.Contains(pathKey(rel)) {
+		found = bf.Contains(pathDigestKey(rel, digest))
+		return found, !found
+	}
+	return bf.Contains(digest), false
+}
+
+// verdict decides what is known about a file from its path relative to
+// the root of the scan and its digests. A match in a known-bad filter
+// takes precedence, then one in a known-good filter, then a known path
+// with other content. It returns the verdict, the filters that decided
+// it and the informational filters that also matched.
+func (s *FilterSet) verdict(rel string, digests map[digestKind]string) (string, []string, []string) {
+	var bad, good, changed, noted []string
+	for _, bf := range s.Filters {
+		found, modified := match(bf, rel, digests)
+		if modified && bf.Role != "known-bad" && bf.Role != "informational" {
+			changed = append(changed, bf.Name)
+		}
+		if !found {
+			continue
+		}
+		switch bf.Role {
+		case "known-bad":
+			bad = append(bad, bf.Name)
+		case "informational":
+			noted = append(noted, bf.Name)
+		default:
+			good = append(good, bf.Name)
+		}
+	}
+	switch {
+	case len(bad) > 0:
+		return verdictKnownBad, bad, noted
+	case len(good) > 0:
+		return verdictKnownGood, good, noted
+	case len(changed) > 0:
+		return verdictModified, changed, noted
+	}
+	return verdictUnknown, nil, noted
+}
+
+// report prints the verdict on a file. Known-bad and modified files are
+// always reported, naming the filters that decided it. Otherwise only unknown
+// files are reported unless verbose is set, and not even those when
+// every filter is known-bad. The file's type is shown when known, and
+// files holding executable content under another type's extension are
+// flagged. With Print0 set, the paths of unknown files are written there
+// instead and known-good files aren't reported, and with Summary set,
+// nothing is printed until the summary.
+func (s *FilterSet) report(f *scannedFile, digests map[digestKind]string, verbose bool) {
+	path, kind := f.Path, f.Type
+	verdict, matched, noted := s.verdict(s.pathRel(f), digests)
+	if s.Verdicts == nil {
+		s.Verdicts = make(map[string]int)
+	}
+	s.Verdicts[verdict]++
+	// Reports list files with the digest held by the first filter.
+	first := digestKind{s.Filters[0].Digest, s.Filters[0].Normalize}
+	digest := first.String() + ":" + digests[first]
+	if s.Report != nil {
+		s.Report.Add(f, verdict, digest)
+	}
+	if s.Sarif != nil {
+		s.Sarif.Add(f, verdict, matched, digest)
+	}
+	if s.Summary != nil {
+		s.Summary.Add(path, verdict, kind)
+		return
+	}
+	if s.Print0 != nil {
+		if verdict == verdictUnknown {
+			fmt.Fprintf(s.Print0, "%s\x00", path)
+			return
+		}
+		verbose = false
+	}
+
+	badOnly := true
+	for _, bf := range s.Filters {
+		badOnly = badOnly && bf.Role == "known-bad"
+	}
+	prefix, suffix := "", ""
+	if kind != "" {
+		suffix = fmt.Sprintf(" (%s)", kind)
+		if expected := disguisedType(path, kind); expected != "" {
+			prefix = "[!] "
+			suffix = fmt.Sprintf(" (%s, named as %s)", kind, expected)
+		}
+	}
+	var reasons []string
+	if verdict == verdictModified {
+		reasons = append(reasons, "changed from "+strings.Join(matched, ", "))
+	} else if len(matched) > 0 {
+		reasons = append(reasons, "matched "+strings.Join(matched, ", "))
+	}
+	if len(noted) > 0 {
+		reasons = append(reasons, "noted in "+strings.Join(noted, ", "))
+	}
+	if len(reasons) > 0 {
+		suffix = fmt.Sprintf(" (%s)", strings.Join(reasons, "; ")) + suffix
+	}
+	switch {
+	case verdict == verdictKnownBad || verdict == verdictModified:
+		fmt.Fprintf(s.Scanner.Out, "[!] %s is %s%s\n", path, verdict, suffix)
+	case verdict == verdictKnownGood && verbose:
+		fmt.Fprintf(s.Scanner.Out, "%s%s is %s%s\n", prefix, path, verdict, suffix)
+	case verdict == verdictUnknown && (verbose || !badOnly || prefix != ""):
+		fmt.Fprintf(s.Scanner.Out, "%s%s is %s%s\n", prefix, path, verdict, suffix)
+	}
+}
+
+// LookupHashes determines if files within a directory have hashes
+// within the filters. When given a single file, only notable results
+// are reported.
+func (s *FilterSet) LookupHashes(path string) {
+	info, err := s.Scanner.Fs.Stat(path)
+	if err != nil {
+		if !(os.IsPermission(err)) {
+			log.Fatal(err)
+		}
+	}
+	verbose := info == nil || info.IsDir()
+	err = s.Scanner.Walk(path, func(f *scannedFile) error {
+		if f.Err != nil {
+			if verbose {
+				fmt.Fprintf(s.Scanner.Out, "%s: Permission Denied\n", f.Path)
+			}
+			return nil
+		}
+		digests, err := s.digests(f)
+		if err != nil {
+			fmt.Fprintf(s.Scanner.Out, "Error reading %s: %v\n", f.Path, err)
+			return nil
+		}
+		s.report(f, digests, verbose)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestCalculateSeveralDigests(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/www/"
+	fs.MkdirAll(fakeDir, 0755)
+	afero.WriteFile(fs, fakeDir+"index.php", []byte("<?php\necho 1;\n"), 0644)
+
+	fakeFilterDir := "/tmp/filters/"
+	fs.MkdirAll(fakeFilterDir, 0755)
+	args := []string{"mdd", "calculate", "--digest", "md5,sha256", fakeFilterDir + "wordpress", fakeDir}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Calculate()
+
+	for _, alg := range []string{"md5", "sha256"} {
+		bloomFilter := NewBloomFilter(1, 0.01, fs)
+		bloomFilter.Load(fakeFilterDir + "wordpress." + alg)
+		digest, _ := hashReader(strings.NewReader("<?php\necho 1;\n"), alg)
+		if bloomFilter.Digest != alg || !bloomFilter.Lookup(digest) {
+			t.Errorf("Calculate: --digest: expected a %s filter holding %s", alg, digest)
+		}
+	}
+}
+
+func TestFilterSetDigests(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/www/"
+	fs.MkdirAll(fakeDir, 0755)
+	afero.WriteFile(fs, fakeDir+"index.php", []byte("<?php\r\necho 1;\r\n"), 0644)
+
+	md5Filter := NewBloomFilter(1, 0.01, fs)
+	sha256Filter := NewBloomFilter(1, 0.01, fs)
+	sha256Filter.Digest = "sha256"
+	sha256Filter.Normalize = normalizeEOL
+	set := &FilterSet{
+		Filters: []*BloomFilter{&md5Filter, &sha256Filter},
+		Scanner: NewScanner(fs),
+	}
+	set.CalculateHashes(fakeDir)
+
+	raw, _ := hashReader(strings.NewReader("<?php\r\necho 1;\r\n"), "md5")
+	normalized, _ := hashReader(strings.NewReader("<?php\necho 1;\n"), "sha256")
+	if !md5Filter.Lookup(raw) {
+		t.Errorf("FilterSet: CalculateHashes: expected md5 filter to hold %s", raw)
+	}
+	if !sha256Filter.Lookup(normalized) {
+		t.Errorf("FilterSet: CalculateHashes: expected sha256 filter to hold %s", normalized)
+	}
+	if kinds := set.kinds(); len(kinds) != 2 {
+		t.Errorf("FilterSet: kinds: expected: 2 actual: %d", len(kinds))
+	}
+}
+
+func TestInstalledFilters(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fs.MkdirAll(filterPath(), 0755)
+	fs.MkdirAll(filterPath()+"/old", 0755)
+	fakeDir := "/var/www/"
+	fs.MkdirAll(fakeDir, 0755)
+	afero.WriteFile(fs, fakeDir+"index.php", []byte("<?php"), 0644)
+	for _, name := range []string{"wordpress-6.4", "wordpress-6.5", "akismet"} {
+		bloomFilter := NewBloomFilter(10, 0.01, fs)
+		// Sidecars are saved next to their filters and must be skipped.
+		bloomFilter.Sidecar = &Sidecar{}
+		bloomFilter.CalculateHashes(fakeDir)
+		bloomFilter.Save(filterPath() + "/" + name)
+	}
+	afero.WriteFile(fs, filterPath()+"/.hidden", []byte("x"), 0644)
+
+	tests := []struct {
+		patterns []string
+		expected []string
+	}{
+		{nil, []string{"akismet", "wordpress-6.4", "wordpress-6.5"}},
+		{[]string{"wordpress-*"}, []string{"wordpress-6.4", "wordpress-6.5"}},
+		{[]string{"akismet", "wordpress-6.5"}, []string{"akismet", "wordpress-6.5"}},
+	}
+	for _, test := range tests {
+		paths, err := installedFilters(fs, test.patterns)
+		if err != nil {
+			t.Errorf("installedFilters: %v: unexpected error: %v", test.patterns, err)
+		}
+		var names []string
+		for _, path := range paths {
+			names = append(names, strings.TrimPrefix(path, filterPath()+"/"))
+		}
+		if strings.Join(names, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("installedFilters: %v: expected: %v actual: %v", test.patterns, test.expected, names)
+		}
+	}
+
+	args := []string{"mdd", "lookup", "--installed", "--no-cache", fakeDir}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Lookup()
+}
+
+func TestFilterSetVerdict(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	digest := func(content string) map[digestKind]string {
+		d, _ := hashReader(strings.NewReader(content), "md5")
+		return map[digestKind]string{{"md5", 0}: d}
+	}
+	newFilter := func(name, role string, contents ...string) *BloomFilter {
+		bf := NewBloomFilter(10, 0.01, fs)
+		bf.Name = name
+		bf.Role = role
+		for _, content := range contents {
+			bf.Add(digest(content)[digestKind{"md5", 0}])
+		}
+		return &bf
+	}
+	set := &FilterSet{Filters: []*BloomFilter{
+		newFilter("wordpress", "known-good", "index.php", "shared.php"),
+		newFilter("webshells", "known-bad", "shell.php", "shared.php"),
+		newFilter("debian", "informational", "index.php", "readme.txt"),
+	}}
+	tests := []struct {
+		content string
+		verdict string
+		matched string
+		noted   string
+	}{
+		{"index.php", verdictKnownGood, "wordpress", "debian"},
+		{"shell.php", verdictKnownBad, "webshells", ""},
+		{"shared.php", verdictKnownBad, "webshells", ""},
+		{"readme.txt", verdictUnknown, "", "debian"},
+		{"other.php", verdictUnknown, "", ""},
+	}
+	for _, test := range tests {
+		verdict, matched, noted := set.verdict("", digest(test.content))
+		if verdict != test.verdict ||
+			strings.Join(matched, ",") != test.matched ||
+			strings.Join(noted, ",") != test.noted {
+			t.Errorf(
+				"FilterSet: verdict: %s: expected: %s %s %s actual: %s %v %v",
+				test.content,
+				test.verdict,
+				test.matched,
+				test.noted,
+				verdict,
+				matched,
+				noted,
+			)
+		}
+	}
+
+	informational := newFilter("debian", "informational", "index.php")
+	informational.Save("/tmp/debian")
+	loaded := NewBloomFilter(1, 0.01, fs)
+	loaded.Load("/tmp/debian")
+	if loaded.Role != "informational" {
+		t.Errorf("BloomFilter: Load: expected: informational actual: %s", loaded.Role)
+	}
+}
+
+func TestPathAwareFilter(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	release := "/tmp/wordpress/"
+	fs.MkdirAll(release+"wp-includes", 0755)
+	fs.MkdirAll(release+"wp-admin", 0755)
+	afero.WriteFile(fs, release+"index.php", []byte("<?php // Silence is golden."), 0644)
+	afero.WriteFile(fs, release+"wp-admin/index.php", []byte("<?php require 'admin.php';"), 0644)
+	afero.WriteFile(fs, release+"wp-includes/load.php", []byte("<?php load();"), 0644)
+
+	fakeFilterDir := "/tmp/filters/"
+	fs.MkdirAll(fakeFilterDir, 0755)
+	args := []string{"mdd", "calculate", "--paths", "--no-cache", fakeFilterDir + "wordpress", release}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Calculate()
+
+	bloomFilter := NewBloomFilter(1, 0.01, fs)
+	bloomFilter.Load(fakeFilterDir + "wordpress")
+	if !bloomFilter.Paths {
+		t.Errorf("Load: expected a path-aware filter")
+	}
+	set := &FilterSet{Filters: []*BloomFilter{&bloomFilter}}
+	digest := func(content string) map[digestKind]string {
+		d, _ := hashReader(strings.NewReader(content), "md5")
+		return map[digestKind]string{{"md5", 0}: d}
+	}
+	tests := []struct {
+		rel     string
+		content string
+		verdict string
+	}{
+		{"wp-includes/load.php", "<?php load();", verdictKnownGood},
+		{"wp-includes/load.php", "<?php load(); eval($_POST['x']);", verdictModified},
+		{"wp-includes/index.php", "<?php // Silence is golden.", verdictKnownGood},
+		{"wp-includes/shell.php", "<?php system($_GET['c']);", verdictUnknown},
+	}
+	for _, test := range tests {
+		if verdict, _, _ := set.verdict(test.rel, digest(test.content)); verdict != test.verdict {
+			t.Errorf("FilterSet: verdict: %s: expected: %s actual: %s", test.rel, test.verdict, verdict)
+		}
+	}
+
+	// Looking up a nested file directly doesn't tell us its path within
+	// the release, so it is matched by content alone.
+	var out bytes.Buffer
+	set.Scanner = NewScanner(fs)
+	set.Scanner.Out = &out
+	set.LookupHashes(release + "wp-admin/index.php")
+	if set.Verdicts[verdictKnownGood] != 1 || strings.Contains(out.String(), verdictModified) {
+		t.Errorf("FilterSet: LookupHashes: wp-admin/index.php: expected: %s actual: %v %q", verdictKnownGood, set.Verdicts, out.String())
+	}
+}
+
+func TestLookupPrint0(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/www/"
+	fs.MkdirAll(fakeDir, 0755)
+	afero.WriteFile(fs, fakeDir+"index.php", []byte("<?php\necho 1;\n"), 0644)
+	afero.WriteFile(fs, fakeDir+"new\nline.php", []byte("<?php\necho 2;\n"), 0644)
+	afero.WriteFile(fs, fakeDir+"shell.php", []byte("<?php system($_GET['c']);\n"), 0644)
+
+	bloomFilter := NewBloomFilter(10, 0.01, fs)
+	digest, _ := hashReader(strings.NewReader("<?php\necho 1;\n"), "md5")
+	bloomFilter.Add(digest)
+	webshells := NewBloomFilter(10, 0.01, fs)
+	webshells.Name, webshells.Role = "webshells", "known-bad"
+	digest, _ = hashReader(strings.NewReader("<?php system($_GET['c']);\n"), "md5")
+	webshells.Add(digest)
+	afero.WriteFile(fs, fakeDir+ignoreFileName, []byte("*.log\n"), 0644)
+	var out, messages bytes.Buffer
+	set := &FilterSet{
+		Filters: []*BloomFilter{&bloomFilter, &webshells},
+		Scanner: NewScanner(fs),
+		Print0:  &out,
+	}
+	set.Scanner.Out = &messages
+	set.Scanner.Filter.IgnoreFile = ignoreFileName
+	set.LookupHashes(fakeDir)
+
+	expected := fakeDir + ignoreFileName + "\x00" + fakeDir + "new\nline.php\x00"
+	if out.String() != expected {
+		t.Errorf("FilterSet: LookupHashes: Print0: expected: %q actual: %q", expected, out.String())
+	}
+	if !strings.Contains(messages.String(), "Reading exclusion rules from "+fakeDir+ignoreFileName) {
+		t.Errorf("FilterSet: LookupHashes: Print0: expected messages on Scanner.Out actual: %q", messages.String())
+	}
+	if !strings.Contains(messages.String(), "[!] "+fakeDir+"shell.php is known-bad") ||
+		strings.Contains(messages.String(), "index.php") {
+		t.Errorf("FilterSet: LookupHashes: Print0: expected only known-bad files on Scanner.Out actual: %q", messages.String())
+	}
+}
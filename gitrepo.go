@@ -0,0 +1,633 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Git object types, as numbered in packfiles.
+const (
+	gitCommit   = 1
+	gitTree     = 2
+	gitBlob     = 3
+	gitTag      = 4
+	gitOfsDelta = 6
+	gitRefDelta = 7
+)
+
+var gitTypeNames = map[string]int{
+	"commit": gitCommit,
+	"tree":   gitTree,
+	"blob":   gitBlob,
+	"tag":    gitTag,
+}
+
+var errGitObjectNotFound = errors.New("object not found")
+
+// gitPack is a packfile and its version 2 index. The packfile is
+// opened for each object read from it.
+type gitPack struct {
+	path    string
+	size    int64
+	fanout  [256]uint32
+	ids     []byte
+	offsets []byte
+	large   []byte
+}
+
+// gitRepo reads objects from a repository's object database without
+// the git binary.
+type gitRepo struct {
+	fs     afero.Fs
+	gitDir string
+	// commonDir holds the objects and refs shared by the worktrees of a
+	// repository. It is gitDir except in linked worktrees.
+	commonDir string
+	packs     []*gitPack
+	// bases caches recently read packed objects.
+	bases map[gitPackOffset]gitObject
+}
+
+// gitPackOffset locates an object in a packfile.
+type gitPackOffset struct {
+	pack   *gitPack
+	offset int64
+}
+
+// gitObject is an object's type and content.
+type gitObject struct {
+	kind int
+	data []byte
+}
+
+// readGitDirFile reads a file in a repository that names another
+// directory, such as the gitdir: line of a .git file or a worktree's
+// commondir, resolving relative paths against the file's directory.
+func readGitDirFile(file, prefix string, fs afero.Fs) (string, error) {
+	content, err := afero.ReadFile(fs, file)
+	if err != nil {
+		return "", err
+	}
+	line := string(content)
+	if end := strings.IndexByte(line, '\n'); end != -1 {
+		line = line[:end]
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return "", fmt.Errorf("%s: expected %q", file, prefix)
+	}
+	target := strings.TrimSpace(strings.TrimPrefix(line, prefix))
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(file), target)
+	}
+	return target, nil
+}
+
+// openGitRepo opens the repository at dir, which may be a working tree
+// or a bare repository. Working trees of submodules and linked
+// worktrees have a .git file pointing to the repository elsewhere.
+func openGitRepo(dir string, fs afero.Fs) (*gitRepo, error) {
+	gitDir := dir
+	if info, err := fs.Stat(filepath.Join(dir, ".git")); err == nil {
+		gitDir = filepath.Join(dir, ".git")
+		if !info.IsDir() {
+			if gitDir, err = readGitDirFile(gitDir, "gitdir:", fs); err != nil {
+				return nil, err
+			}
+		}
+	}
+	commonDir := gitDir
+	if _, err := fs.Stat(filepath.Join(gitDir, "commondir")); err == nil {
+		// Linked worktrees keep their HEAD to themselves and share the
+		// rest of the repository.
+		target, err := readGitDirFile(filepath.Join(gitDir, "commondir"), "", fs)
+		if err != nil {
+			return nil, err
+		}
+		commonDir = target
+	}
+	if _, err := fs.Stat(filepath.Join(commonDir, "objects")); err != nil {
+		return nil, fmt.Errorf("%s: not a git repository", dir)
+	}
+	repo := &gitRepo{
+		fs:        fs,
+		gitDir:    gitDir,
+		commonDir: commonDir,
+		bases:     make(map[gitPackOffset]gitObject),
+	}
+	indexes, err := afero.Glob(fs, filepath.Join(commonDir, "objects", "pack", "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		pack, err := openGitPack(index, fs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", index, err)
+		}
+		repo.packs = append(repo.packs, pack)
+	}
+	return repo, nil
+}
+
+// openGitPack reads a pack index and opens the packfile beside it.
+func openGitPack(index string, fs afero.Fs) (*gitPack, error) {
+	content, err := afero.ReadFile(fs, index)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < 8+256*4 || !bytes.HasPrefix(content, []byte("\377tOc")) ||
+		binary.BigEndian.Uint32(content[4:8]) != 2 {
+		return nil, errors.New("unsupported pack index version")
+	}
+	pack := &gitPack{}
+	for i := range pack.fanout {
+		pack.fanout[i] = binary.BigEndian.Uint32(content[8+i*4:])
+	}
+	count := int(pack.fanout[255])
+	start := 8 + 256*4
+	if len(content) < start+count*(20+4+4) {
+		return nil, errors.New("truncated pack index")
+	}
+	pack.ids = content[start : start+count*20]
+	start += count * 20
+	start += count * 4 // CRCs
+	pack.offsets = content[start : start+count*4]
+	pack.large = content[start+count*4:]
+	pack.path = strings.TrimSuffix(index, ".idx") + ".pack"
+	info, err := fs.Stat(pack.path)
+	if err != nil {
+		return nil, err
+	}
+	pack.size = info.Size()
+	return pack, nil
+}
+
+// find returns the offset of an object in the packfile.
+func (p *gitPack) find(id []byte) (int64, bool) {
+	low := 0
+	if id[0] > 0 {
+		low = int(p.fanout[id[0]-1])
+	}
+	high := int(p.fanout[id[0]])
+	for low < high {
+		mid := (low + high) / 2
+		switch bytes.Compare(p.ids[mid*20:mid*20+20], id) {
+		case 0:
+			offset := binary.BigEndian.Uint32(p.offsets[mid*4:])
+			if offset&0x80000000 == 0 {
+				return int64(offset), true
+			}
+			large := int(offset&0x7fffffff) * 8
+			if large+8 > len(p.large) {
+				return 0, false
+			}
+			return int64(binary.BigEndian.Uint64(p.large[large:])), true
+		case -1:
+			low = mid + 1
+		default:
+			high = mid
+		}
+	}
+	return 0, false
+}
+
+// read returns the object with the given hex id.
+func (r *gitRepo) read(id string) (gitObject, error) {
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != 20 {
+		return gitObject{}, fmt.Errorf("invalid object id %q", id)
+	}
+	for _, pack := range r.packs {
+		if offset, ok := pack.find(raw); ok {
+			return r.readPacked(pack, offset)
+		}
+	}
+	return r.readLoose(id)
+}
+
+// readLoose reads an object stored in its own zlib compressed file.
+func (r *gitRepo) readLoose(id string) (gitObject, error) {
+	f, err := r.fs.Open(filepath.Join(r.commonDir, "objects", id[:2], id[2:]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return gitObject{}, fmt.Errorf("%s: %v", id, errGitObjectNotFound)
+		}
+		return gitObject{}, err
+	}
+	defer f.Close()
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return gitObject{}, err
+	}
+	defer z.Close()
+	content, err := ioutil.ReadAll(z)
+	if err != nil {
+		return gitObject{}, err
+	}
+	nul := bytes.IndexByte(content, 0)
+	if nul == -1 {
+		return gitObject{}, fmt.Errorf("%s: malformed object", id)
+	}
+	header := strings.Fields(string(content[:nul]))
+	if len(header) != 2 {
+		return gitObject{}, fmt.Errorf("%s: malformed object", id)
+	}
+	kind, ok := gitTypeNames[header[0]]
+	if !ok {
+		return gitObject{}, fmt.Errorf("%s: unknown object type %s", id, header[0])
+	}
+	return gitObject{kind: kind, data: content[nul+1:]}, nil
+}
+
+// readPacked reads the object at offset in a packfile, applying deltas.
+func (r *gitRepo) readPacked(pack *gitPack, offset int64) (gitObject, error) {
+	key := gitPackOffset{pack, offset}
+	if object, ok := r.bases[key]; ok {
+		return object, nil
+	}
+	f, err := r.fs.Open(pack.path)
+	if err != nil {
+		return gitObject{}, err
+	}
+	defer f.Close()
+	section := bufio.NewReader(io.NewSectionReader(f, offset, pack.size-offset))
+	b, err := section.ReadByte()
+	if err != nil {
+		return gitObject{}, err
+	}
+	kind := int(b>>4) & 7
+	for b&0x80 != 0 {
+		// The rest of the header is the inflated size, which we don't need.
+		if b, err = section.ReadByte(); err != nil {
+			return gitObject{}, err
+		}
+	}
+
+	var base gitObject
+	switch kind {
+	case gitOfsDelta:
+		b, err := section.ReadByte()
+		if err != nil {
+			return gitObject{}, err
+		}
+		distance := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = section.ReadByte(); err != nil {
+				return gitObject{}, err
+			}
+			distance = (distance+1)<<7 | int64(b&0x7f)
+		}
+		if base, err = r.readPacked(pack, offset-distance); err != nil {
+			return gitObject{}, err
+		}
+	case gitRefDelta:
+		id := make([]byte, 20)
+		if _, err := io.ReadFull(section, id); err != nil {
+			return gitObject{}, err
+		}
+		if base, err = r.read(hex.EncodeToString(id)); err != nil {
+			return gitObject{}, err
+		}
+	}
+
+	z, err := zlib.NewReader(section)
+	if err != nil {
+		return gitObject{}, err
+	}
+	data, err := ioutil.ReadAll(z)
+	z.Close()
+	if err != nil {
+		return gitObject{}, err
+	}
+	object := gitObject{kind: kind, data: data}
+	if kind == gitOfsDelta || kind == gitRefDelta {
+		if data, err = applyGitDelta(base.data, data); err != nil {
+			return gitObject{}, err
+		}
+		object = gitObject{kind: base.kind, data: data}
+	}
+	if len(object.data) < 1<<20 {
+		// Delta bases are read again for each object built on them, so
+		// keep the smaller objects around.
+		if len(r.bases) > 256 {
+			r.bases = make(map[gitPackOffset]gitObject)
+		}
+		r.bases[key] = object
+	}
+	return object, nil
+}
+
+// gitDeltaSize reads one of the sizes at the start of a delta.
+func gitDeltaSize(delta []byte) (int, []byte) {
+	size, shift := 0, uint(0)
+	for len(delta) > 0 {
+		b := delta[0]
+		delta = delta[1:]
+		size |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return size, delta
+}
+
+// applyGitDelta rebuilds an object from its base and a delta of copy
+// and insert instructions.
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta := gitDeltaSize(delta)
+	if baseSize != len(base) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	size, delta := gitDeltaSize(delta)
+	result := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 == 0 {
+			// Insert the next op bytes.
+			n := int(op)
+			if n == 0 || n > len(delta) {
+				return nil, errors.New("invalid delta")
+			}
+			result = append(result, delta[:n]...)
+			delta = delta[n:]
+			continue
+		}
+		// Copy from the base, with offset and size bytes present as
+		// flagged by the low seven bits of op.
+		var offset, n int
+		for i := uint(0); i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errors.New("invalid delta")
+			}
+			if i < 4 {
+				offset |= int(delta[0]) << (8 * i)
+			} else {
+				n |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if n == 0 {
+			n = 0x10000
+		}
+		if offset+n > len(base) {
+			return nil, errors.New("invalid delta")
+		}
+		result = append(result, base[offset:offset+n]...)
+	}
+	if len(result) != size {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return result, nil
+}
+
+// readRef returns the object id a ref points to, following symbolic
+// refs such as HEAD, or "" if there is no such ref.
+func (r *gitRepo) readRef(name string) (string, error) {
+	for i := 0; i < 8; i++ {
+		content, err := afero.ReadFile(r.fs, filepath.Join(r.gitDir, filepath.FromSlash(name)))
+		if err != nil && r.commonDir != r.gitDir {
+			content, err = afero.ReadFile(r.fs, filepath.Join(r.commonDir, filepath.FromSlash(name)))
+		}
+		if err != nil {
+			return r.packedRef(name)
+		}
+		value := strings.TrimSpace(string(content))
+		if !strings.HasPrefix(value, "ref:") {
+			if !isObjectID(value) {
+				// Not a ref, such as the config file.
+				return r.packedRef(name)
+			}
+			return value, nil
+		}
+		name = strings.TrimSpace(strings.TrimPrefix(value, "ref:"))
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic refs", name)
+}
+
+// packedRef looks a ref up in the packed-refs file.
+func (r *gitRepo) packedRef(name string) (string, error) {
+	f, err := r.fs.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// isObjectID reports whether value is a full hex object id.
+func isObjectID(value string) bool {
+	_, err := hex.DecodeString(value)
+	return len(value) == 40 && err == nil
+}
+
+// resolve finds the object a revision names: a full object id, or a
+// ref name looked up the way git does, so v1.2.3 finds refs/tags/v1.2.3.
+func (r *gitRepo) resolve(rev string) (string, error) {
+	if isObjectID(rev) {
+		return strings.ToLower(rev), nil
+	}
+	for _, name := range []string{
+		rev,
+		"refs/" + rev,
+		"refs/tags/" + rev,
+		"refs/heads/" + rev,
+		"refs/remotes/" + rev,
+		"refs/remotes/" + rev + "/HEAD",
+	} {
+		id, err := r.readRef(name)
+		if err != nil {
+			return "", err
+		}
+		if id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("unknown revision %s", rev)
+}
+
+// gitHeader returns the value of a header line, such as the tree of a
+// commit, from a commit or tag object.
+func gitHeader(data []byte, name string) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, name+" ") {
+			return strings.TrimPrefix(line, name+" ")
+		}
+	}
+	return ""
+}
+
+// treeOf peels tags and commits down to a tree, returning it along
+// with the commit's time.
+func (r *gitRepo) treeOf(id string) (string, time.Time, error) {
+	var modTime time.Time
+	for i := 0; i < 16; i++ {
+		object, err := r.read(id)
+		if err != nil {
+			return "", modTime, err
+		}
+		switch object.kind {
+		case gitTag:
+			id = gitHeader(object.data, "object")
+		case gitCommit:
+			fields := strings.Fields(gitHeader(object.data, "committer"))
+			if len(fields) >= 2 {
+				if seconds, err := strconv.ParseInt(fields[len(fields)-2], 10, 64); err == nil {
+					modTime = time.Unix(seconds, 0)
+				}
+			}
+			id = gitHeader(object.data, "tree")
+		case gitTree:
+			return id, modTime, nil
+		default:
+			return "", modTime, fmt.Errorf("%s is not a commit or tree", id)
+		}
+	}
+	return "", modTime, fmt.Errorf("%s: too many levels of tags", id)
+}
+
+// gitFileInfo describes a file in a git tree. Finding a blob's size
+// means reading it, so that waits until the size is asked for.
+type gitFileInfo struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+	size    func() int64
+}
+
+func (g gitFileInfo) Name() string       { return g.name }
+func (g gitFileInfo) Size() int64        { return g.size() }
+func (g gitFileInfo) Mode() os.FileMode  { return g.mode }
+func (g gitFileInfo) ModTime() time.Time { return g.modTime }
+func (g gitFileInfo) IsDir() bool        { return g.mode.IsDir() }
+func (g gitFileInfo) Sys() interface{}   { return nil }
+
+// addTree adds the entries of a tree to fs under dir. Submodules are
+// left out, as their contents live in another repository.
+func (r *gitRepo) addTree(fs *indexFs, dir, id string, modTime time.Time) error {
+	object, err := r.read(id)
+	if err != nil {
+		return err
+	}
+	if object.kind != gitTree {
+		return fmt.Errorf("%s is not a tree", id)
+	}
+	data := object.data
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space == -1 || nul < space || nul+21 > len(data) {
+			return fmt.Errorf("%s: malformed tree", id)
+		}
+		mode, err := strconv.ParseUint(string(data[:space]), 8, 32)
+		if err != nil {
+			return fmt.Errorf("%s: malformed tree", id)
+		}
+		name := string(data[space+1 : nul])
+		entryID := hex.EncodeToString(data[nul+1 : nul+21])
+		data = data[nul+21:]
+
+		entryPath := path.Join(dir, name)
+		info := gitFileInfo{name: name, modTime: modTime, mode: os.FileMode(mode & 0777)}
+		switch mode & 0170000 {
+		case 0040000:
+			info.mode = os.ModeDir | 0755
+			info.size = func() int64 { return 0 }
+			fs.add(entryPath, &indexEntry{info: info})
+			if err := r.addTree(fs, entryPath, entryID, modTime); err != nil {
+				return err
+			}
+		case 0100000, 0120000:
+			if mode&0170000 == 0120000 {
+				info.mode = os.ModeSymlink | 0777
+			}
+			entry := &indexEntry{info: info}
+			blobID := entryID
+			var size int64 = -1
+			entry.open = func() (io.ReadCloser, error) {
+				object, err := r.read(blobID)
+				if err != nil {
+					return nil, err
+				}
+				return ioutil.NopCloser(bytes.NewReader(object.data)), nil
+			}
+			info.size = func() int64 {
+				if size == -1 {
+					object, _ := r.read(blobID)
+					size = int64(len(object.data))
+				}
+				return size
+			}
+			entry.info = info
+			fs.add(entryPath, entry)
+		}
+	}
+	return nil
+}
+
+// NewGitFs returns a read-only filesystem holding the tree of a
+// revision of the git repository at dir. The revision is a full object
+// id or a branch, tag or other ref name, and defaults to HEAD.
+func NewGitFs(dir, rev string, fs afero.Fs) (afero.Fs, error) {
+	repo, err := openGitRepo(dir, fs)
+	if err != nil {
+		return nil, err
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	id, err := repo.resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, modTime, err := repo.treeOf(id)
+	if err != nil {
+		return nil, err
+	}
+	gitFs := newIndexFs(dir + "@" + rev)
+	if err := repo.addTree(gitFs, "/", tree, modTime); err != nil {
+		return nil, err
+	}
+	return gitFs, nil
+}
+
+// splitGitSource splits a --git argument of the form repo@ref at its
+// last @, since paths hold an @ more often than refs do. An argument
+// naming a directory is taken as a repo with no ref.
+func splitGitSource(source string, fs afero.Fs) (string, string) {
+	if info, err := fs.Stat(source); err == nil && info.IsDir() {
+		return source, ""
+	}
+	if at := strings.LastIndex(source, "@"); at > 0 {
+		return source[:at], source[at+1:]
+	}
+	return source, ""
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func gitObjectID(kind string, data []byte) []byte {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s %d\x00%s", kind, len(data), data)))
+	return sum[:]
+}
+
+func zlibCompress(data []byte) []byte {
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	z.Write(data)
+	z.Close()
+	return buf.Bytes()
+}
+
+// writeLooseObject stores an object in a repository's objects directory
+// and returns its hex id.
+func writeLooseObject(fs afero.Fs, gitDir, kind string, data []byte) string {
+	id := hex.EncodeToString(gitObjectID(kind, data))
+	content := zlibCompress([]byte(fmt.Sprintf("%s %d\x00%s", kind, len(data), data)))
+	fs.MkdirAll(gitDir+"/objects/"+id[:2], 0755)
+	afero.WriteFile(fs, gitDir+"/objects/"+id[:2]+"/"+id[2:], content, 0644)
+	return id
+}
+
+// gitTreeEntry formats an entry of a tree object.
+func gitTreeEntry(mode, name, id string) []byte {
+	raw, _ := hex.DecodeString(id)
+	return append([]byte(mode+" "+name+"\x00"), raw...)
+}
+
+// writePack stores a packfile holding base as a blob and target as an
+// offset delta against it, along with its index.
+func writePack(fs afero.Fs, gitDir string, base, target []byte) {
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+
+	baseOffset := pack.Len()
+	// Sizes under 16 bytes fit in the first header byte.
+	pack.WriteByte(byte(gitBlob<<4 | len(base)))
+	pack.Write(zlibCompress(base))
+
+	// The delta copies the base and appends the rest of target.
+	rest := target[len(base):]
+	delta := []byte{byte(len(base)), byte(len(target))}
+	delta = append(delta, 0x80|0x10, byte(len(base)))
+	delta = append(delta, byte(len(rest)))
+	delta = append(delta, rest...)
+	targetOffset := pack.Len()
+	pack.WriteByte(byte(gitOfsDelta<<4 | len(delta)))
+	pack.WriteByte(byte(targetOffset - baseOffset))
+	pack.Write(zlibCompress(delta))
+
+	objects := []struct {
+		id     []byte
+		offset int
+	}{
+		{gitObjectID("blob", base), baseOffset},
+		{gitObjectID("blob", target), targetOffset},
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return bytes.Compare(objects[i].id, objects[j].id) < 0
+	})
+	var index bytes.Buffer
+	index.WriteString("\377tOc")
+	binary.Write(&index, binary.BigEndian, uint32(2))
+	for i := 0; i < 256; i++ {
+		count := uint32(0)
+		for _, object := range objects {
+			if int(object.id[0]) <= i {
+				count++
+			}
+		}
+		binary.Write(&index, binary.BigEndian, count)
+	}
+	for _, object := range objects {
+		index.Write(object.id)
+	}
+	for range objects {
+		binary.Write(&index, binary.BigEndian, uint32(0))
+	}
+	for _, object := range objects {
+		binary.Write(&index, binary.BigEndian, uint32(object.offset))
+	}
+
+	fs.MkdirAll(gitDir+"/objects/pack", 0755)
+	afero.WriteFile(fs, gitDir+"/objects/pack/pack-test.pack", pack.Bytes(), 0644)
+	afero.WriteFile(fs, gitDir+"/objects/pack/pack-test.idx", index.Bytes(), 0644)
+}
+
+func TestGitFs(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	repo := "/var/data/site"
+	gitDir := repo + "/.git"
+	fs.MkdirAll(gitDir+"/refs/heads", 0755)
+
+	base := []byte("<?php\n")
+	target := []byte("<?php\necho 1;\n")
+	writePack(fs, gitDir, base, target)
+	indexID := hex.EncodeToString(gitObjectID("blob", target))
+	readmeID := writeLooseObject(fs, gitDir, "blob", []byte("readme"))
+	libID := writeLooseObject(fs, gitDir, "tree", gitTreeEntry("100644", "lib.php", readmeID))
+	var tree []byte
+	tree = append(tree, gitTreeEntry("100644", "README", readmeID)...)
+	tree = append(tree, gitTreeEntry("100755", "index.php", indexID)...)
+	tree = append(tree, gitTreeEntry("40000", "inc", libID)...)
+	tree = append(tree, gitTreeEntry("120000", "link", readmeID)...)
+	treeID := writeLooseObject(fs, gitDir, "tree", tree)
+	commitID := writeLooseObject(fs, gitDir, "commit", []byte(
+		"tree "+treeID+"\nauthor A <a@b> 1700000000 +0000\n"+
+			"committer A <a@b> 1700000000 +0000\n\nRelease\n",
+	))
+	tagID := writeLooseObject(fs, gitDir, "tag", []byte(
+		"object "+commitID+"\ntype commit\ntag v1.2.3\ntagger A <a@b> 1700000000 +0000\n\nv1.2.3\n",
+	))
+	afero.WriteFile(fs, gitDir+"/HEAD", []byte("ref: refs/heads/main\n"), 0644)
+	afero.WriteFile(fs, gitDir+"/refs/heads/main", []byte(commitID+"\n"), 0644)
+	afero.WriteFile(fs, gitDir+"/packed-refs", []byte(
+		"# pack-refs with: peeled fully-peeled sorted\n"+tagID+" refs/tags/v1.2.3\n^"+commitID+"\n",
+	), 0644)
+
+	for _, rev := range []string{"", "main", "v1.2.3", commitID} {
+		tree, err := NewGitFs(repo, rev, fs)
+		if err != nil {
+			t.Errorf("NewGitFs: %q: unexpected error: %v", rev, err)
+			continue
+		}
+		var paths []string
+		scanner := NewScanner(tree)
+		scanner.Walk("/", func(f *scannedFile) error {
+			paths = append(paths, f.Path)
+			return nil
+		})
+		expected := []string{"/README", "/inc/lib.php", "/index.php"}
+		if strings.Join(paths, " ") != strings.Join(expected, " ") {
+			t.Errorf("NewGitFs: %q: expected: %v actual: %v", rev, expected, paths)
+		}
+		content, err := afero.ReadFile(tree, "/index.php")
+		if err != nil || !bytes.Equal(content, target) {
+			t.Errorf("NewGitFs: %q: expected delta content: %q actual: %q (%v)", rev, target, content, err)
+		}
+		info, _ := tree.Stat("/index.php")
+		if info.Size() != int64(len(target)) || info.Mode().Perm() != 0755 || info.ModTime().Unix() != 1700000000 {
+			t.Errorf("NewGitFs: %q: unexpected file info: %d %v %v", rev, info.Size(), info.Mode(), info.ModTime())
+		}
+	}
+
+	if _, err := NewGitFs(repo, "v9.9.9", fs); err == nil {
+		t.Errorf("NewGitFs: expected an error for an unknown revision")
+	}
+
+	// A submodule's .git file points to the repository relative to the
+	// working tree; a linked worktree's to a directory of its own that
+	// shares the rest of the repository.
+	fs.MkdirAll("/var/data/module", 0755)
+	afero.WriteFile(fs, "/var/data/module/.git", []byte("gitdir: ../site/.git\n"), 0644)
+	fs.MkdirAll("/var/data/worktree", 0755)
+	afero.WriteFile(fs, "/var/data/worktree/.git", []byte("gitdir: "+gitDir+"/worktrees/worktree\n"), 0644)
+	fs.MkdirAll(gitDir+"/worktrees/worktree", 0755)
+	afero.WriteFile(fs, gitDir+"/worktrees/worktree/commondir", []byte("../..\n"), 0644)
+	afero.WriteFile(fs, gitDir+"/worktrees/worktree/HEAD", []byte(commitID+"\n"), 0644)
+	for _, dir := range []string{"/var/data/module", "/var/data/worktree"} {
+		for _, rev := range []string{"", "v1.2.3"} {
+			tree, err := NewGitFs(dir, rev, fs)
+			if err != nil {
+				t.Errorf("NewGitFs: %s %q: unexpected error: %v", dir, rev, err)
+				continue
+			}
+			if content, _ := afero.ReadFile(tree, "/README"); string(content) != "readme" {
+				t.Errorf("NewGitFs: %s %q: expected: readme actual: %q", dir, rev, content)
+			}
+		}
+	}
+
+	fs.MkdirAll("/srv/user@host/site", 0755)
+	for source, expected := range map[string][2]string{
+		"/srv/user@host/site@v1.2.3": {"/srv/user@host/site", "v1.2.3"},
+		"/srv/user@host/site":        {"/srv/user@host/site", ""},
+		"/srv/site@main":             {"/srv/site", "main"},
+		"/srv/site":                  {"/srv/site", ""},
+	} {
+		repoPath, rev := splitGitSource(source, fs)
+		if repoPath != expected[0] || rev != expected[1] {
+			t.Errorf("splitGitSource: %s: expected: %v actual: %s %s", source, expected, repoPath, rev)
+		}
+	}
+}
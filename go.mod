@@ -5,4 +5,5 @@ go 1.12
 require (
 	github.com/roberson-io/mmh3 v0.0.0-20190715234734-56144817ff83
 	github.com/spf13/afero v1.2.2
+	github.com/ulikunitz/xz v0.5.15
 )
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// hashCacheHeader is the first line of a hash cache file. Files with
+// another header are ignored, so the format can change.
+const hashCacheHeader = "mdd hash cache 2"
+
+// hashCacheSettle is how long after a file last changed its digest may
+// be cached by default. A file written in the same instant it was
+// hashed could change again without its times changing.
+const hashCacheSettle = 2 * time.Second
+
+// hashCacheMaxAge is how long a file may go without being scanned before
+// it is dropped from the cache. Entries record when they were last used
+// to the nearest hashCacheRefresh, so scans of unchanged files don't
+// rewrite the cache every time.
+const (
+	hashCacheMaxAge  = 30 * 24 * time.Hour
+	hashCacheRefresh = 24 * time.Hour
+)
+
+// HashCache remembers the digests of files between scans, so files that
+// haven't changed since aren't read again. Files are identified by
+// device and inode number, and a cached digest is only used while the
+// file's size, modification time and change time are what they were
+// when it was hashed. The change time can't be set back like the
+// modification time can. Files without inode numbers, such as archive
+// members, aren't cached. Files no scan has used for hashCacheMaxAge are
+// dropped when the cache is saved, so one cache can be shared by scans of
+// different trees without growing without bound.
+type HashCache struct {
+	Fs   afero.Fs
+	Path string
+	// entries holds what is cached for each file by device and inode.
+	entries map[hashCacheKey]*hashCacheEntry
+	changed bool
+	// settle is how long after a file last changed it may be cached.
+	settle time.Duration
+	// maxAge is how long an entry is kept without being used.
+	maxAge time.Duration
+}
+
+type hashCacheKey struct {
+	dev, ino uint64
+}
+
+// hashCacheEntry holds the digests of a file, keyed by digestKind,
+// along with the state of the file they were calculated for and when
+// they were last used.
+type hashCacheEntry struct {
+	size    int64
+	mtime   int64
+	ctime   int64
+	used    int64
+	digests map[string]string
+}
+
+// NewHashCache loads the hash cache stored in path. A missing or
+// unreadable cache file gives an empty cache.
+func NewHashCache(path string, fs afero.Fs) *HashCache {
+	c := &HashCache{
+		Fs:      fs,
+		Path:    path,
+		entries: make(map[hashCacheKey]*hashCacheEntry),
+		settle:  hashCacheSettle,
+		maxAge:  hashCacheMaxAge,
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return c
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != hashCacheHeader {
+		return c
+	}
+	for scanner.Scan() {
+		key, entry, ok := parseHashCacheLine(scanner.Text())
+		if ok {
+			c.entries[key] = entry
+		}
+	}
+	return c
+}
+
+// parseHashCacheLine parses a line of a cache file:
+//
+//	<dev> <inode> <size> <mtime> <ctime> <used> <kind>=<digest> ...
+//
+// with times in nanoseconds since the epoch.
+func parseHashCacheLine(line string) (hashCacheKey, *hashCacheEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 7 {
+		return hashCacheKey{}, nil, false
+	}
+	var numbers [6]int64
+	for i := range numbers {
+		n, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return hashCacheKey{}, nil, false
+		}
+		numbers[i] = n
+	}
+	entry := &hashCacheEntry{
+		size:    numbers[2],
+		mtime:   numbers[3],
+		ctime:   numbers[4],
+		used:    numbers[5],
+		digests: make(map[string]string),
+	}
+	for _, field := range fields[6:] {
+		eq := strings.Index(field, "=")
+		if eq < 1 {
+			return hashCacheKey{}, nil, false
+		}
+		entry.digests[field[:eq]] = field[eq+1:]
+	}
+	key := hashCacheKey{uint64(numbers[0]), uint64(numbers[1])}
+	return key, entry, true
+}
+
+// stat returns the key and state of a file, or false if it can't be
+// cached.
+func (c *HashCache) stat(info os.FileInfo) (hashCacheKey, hashCacheEntry, bool) {
+	dev, ino, ok := fileID(info)
+	if !ok {
+		return hashCacheKey{}, hashCacheEntry{}, false
+	}
+	ctime, ok := changeTime(info)
+	if !ok {
+		return hashCacheKey{}, hashCacheEntry{}, false
+	}
+	state := hashCacheEntry{
+		size:  info.Size(),
+		mtime: info.ModTime().UnixNano(),
+		ctime: ctime.UnixNano(),
+	}
+	return hashCacheKey{dev, ino}, state, true
+}
+
+// Get returns the cached digest of a file, if the file hasn't changed
+// since it was cached. Entries for files that have changed are dropped.
+func (c *HashCache) Get(info os.FileInfo, kind string) (string, bool) {
+	key, state, ok := c.stat(info)
+	if !ok {
+		return "", false
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if entry.size != state.size || entry.mtime != state.mtime || entry.ctime != state.ctime {
+		delete(c.entries, key)
+		c.changed = true
+		return "", false
+	}
+	c.use(entry)
+	digest, ok := entry.digests[kind]
+	return digest, ok
+}
+
+// Put caches the digest of a file.
+func (c *HashCache) Put(info os.FileInfo, kind, digest string) {
+	key, state, ok := c.stat(info)
+	if !ok {
+		return
+	}
+	settled := time.Now().Add(-c.settle).UnixNano()
+	if state.mtime > settled || state.ctime > settled {
+		return
+	}
+	entry, ok := c.entries[key]
+	if !ok || entry.size != state.size || entry.mtime != state.mtime || entry.ctime != state.ctime {
+		entry = &state
+		entry.digests = make(map[string]string)
+		c.entries[key] = entry
+	}
+	c.use(entry)
+	if entry.digests[kind] != digest {
+		entry.digests[kind] = digest
+		c.changed = true
+	}
+}
+
+// use records that an entry was used, at most once per
+// hashCacheRefresh.
+func (c *HashCache) use(entry *hashCacheEntry) {
+	now := time.Now().UnixNano()
+	if now-entry.used >= int64(hashCacheRefresh) {
+		entry.used = now
+		c.changed = true
+	}
+}
+
+// Save writes the cache back to its file if anything changed, leaving
+// out files that haven't been used for maxAge. The file is replaced in
+// one step, so an interrupted save leaves the old cache.
+func (c *HashCache) Save() error {
+	expired := time.Now().Add(-c.maxAge).UnixNano()
+	for key, entry := range c.entries {
+		if entry.used < expired {
+			delete(c.entries, key)
+			c.changed = true
+		}
+	}
+	if !c.changed {
+		return nil
+	}
+	if err := c.Fs.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+	tmp := c.Path + ".tmp"
+	f, err := c.Fs.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, hashCacheHeader)
+	for key, entry := range c.entries {
+		kinds := make([]string, 0, len(entry.digests))
+		for kind := range entry.digests {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(w, "%d %d %d %d %d %d", key.dev, key.ino, entry.size, entry.mtime, entry.ctime, entry.used)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, " %s=%s", kind, entry.digests[kind])
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := c.Fs.Rename(tmp, c.Path); err != nil {
+		return err
+	}
+	c.changed = false
+	return nil
+}
+
+// defaultHashCachePath is where the hash cache is kept unless --cache
+// names another file.
+func defaultHashCachePath() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "mdd", "hashes")
+	}
+	return filepath.Join(currentDir(), "hashes.cache")
+}
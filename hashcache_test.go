@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestHashCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdd")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	var fs = afero.NewOsFs()
+	file := filepath.Join(dir, "index.php")
+	ioutil.WriteFile(file, []byte("<?php"), 0644)
+	info, _ := fs.Stat(file)
+	if _, _, ok := fileID(info); !ok {
+		t.Skip("hash cache: no inode numbers on this platform")
+	}
+	cachePath := filepath.Join(dir, "cache", "hashes")
+
+	cache := NewHashCache(cachePath, fs)
+	cache.Put(info, "md5", "0123")
+	if _, ok := cache.Get(info, "md5"); ok {
+		t.Errorf("HashCache: Put: expected a file changed just now not to be cached")
+	}
+	cache.settle = 0
+	cache.Put(info, "md5", "0123")
+	cache.Put(info, digestKind{"md5", normalizeEOL}.String(), "4567")
+	if err := cache.Save(); err != nil {
+		t.Fatalf("HashCache: Save: unexpected error: %v", err)
+	}
+
+	cache = NewHashCache(cachePath, fs)
+	for kind, expected := range map[string]string{"md5": "0123", "md5/1": "4567"} {
+		if digest, ok := cache.Get(info, kind); !ok || digest != expected {
+			t.Errorf("HashCache: Get: %s: expected: %s actual: %s", kind, expected, digest)
+		}
+	}
+	if _, ok := cache.Get(info, "sha256"); ok {
+		t.Errorf("HashCache: Get: expected no sha256 digest")
+	}
+
+	// Changing the file invalidates what was cached for it.
+	ioutil.WriteFile(file, []byte("<?php system($_GET['c']);"), 0644)
+	changed, _ := fs.Stat(file)
+	if _, ok := cache.Get(changed, "md5"); ok {
+		t.Errorf("HashCache: Get: expected no digest for a changed file")
+	}
+
+	// Digests in the cache are used instead of reading the file.
+	fake := strings.Repeat("0", 32)
+	cache = NewHashCache(cachePath, fs)
+	cache.settle = 0
+	cache.Put(changed, "md5", fake)
+	bf := NewBloomFilter(10, 0.01, fs)
+	bf.Cache = cache
+	bf.CalculateHashes(file)
+	if !bf.Lookup(fake) {
+		t.Errorf("HashCache: CalculateHashes: expected the cached digest to be used")
+	}
+
+	// Files not scanned this time are kept, unless no scan has used them
+	// for maxAge.
+	other := filepath.Join(dir, "other.php")
+	ioutil.WriteFile(other, []byte("<?php"), 0644)
+	otherInfo, _ := fs.Stat(other)
+	cache.Put(otherInfo, "md5", fake)
+	if err := cache.Save(); err != nil {
+		t.Fatalf("HashCache: Save: unexpected error: %v", err)
+	}
+	cache = NewHashCache(cachePath, fs)
+	cache.Get(changed, "md5")
+	if err := cache.Save(); err != nil {
+		t.Fatalf("HashCache: Save: unexpected error: %v", err)
+	}
+	cache = NewHashCache(cachePath, fs)
+	if digest, _ := cache.Get(otherInfo, "md5"); digest != fake {
+		t.Errorf("HashCache: Save: expected the file not scanned to be kept actual: %s", digest)
+	}
+	key, _, _ := cache.stat(otherInfo)
+	cache.entries[key].used = time.Now().Add(-hashCacheMaxAge - time.Hour).UnixNano()
+	if err := cache.Save(); err != nil {
+		t.Fatalf("HashCache: Save: unexpected error: %v", err)
+	}
+	cache = NewHashCache(cachePath, fs)
+	if len(cache.entries) != 1 {
+		t.Errorf("HashCache: Save: expected: 1 entry actual: %d", len(cache.entries))
+	}
+	if _, ok := cache.Get(otherInfo, "md5"); ok {
+		t.Errorf("HashCache: Save: expected the file not used for %v to be dropped", hashCacheMaxAge)
+	}
+	if digest, _ := cache.Get(changed, "md5"); digest != fake {
+		t.Errorf("HashCache: Save: expected: %s actual: %s", fake, digest)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseHashLine extracts a digest from a line of a hash list. Lines may
+// hold a bare digest, the "digest  filename" output of md5sum and
+// friends, the "MD5 (filename) = digest" output of BSD md5, or comma
+// separated values. The md5sum and BSD forms are tried first, since
+// their file names may hold commas. For CSV, column picks the field
+// holding the digest, counting from 1; with column 0 the first field
+// that looks like a digest is used. The digest is returned in lower
+// case, or "" if the line has none.
+func parseHashLine(line string, column int) string {
+	line = strings.TrimSpace(line)
+	if column == 0 {
+		if eq := strings.LastIndex(line, " = "); eq != -1 {
+			if digest := normalizeDigest(line[eq+3:]); digest != "" {
+				return digest
+			}
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			if digest := normalizeDigest(fields[0]); digest != "" {
+				return digest
+			}
+		}
+		if !strings.Contains(line, ",") {
+			return ""
+		}
+	}
+	record, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil {
+		return ""
+	}
+	if column > 0 {
+		if column > len(record) {
+			return ""
+		}
+		return normalizeDigest(record[column-1])
+	}
+	for _, field := range record {
+		if digest := normalizeDigest(field); digest != "" {
+			return digest
+		}
+	}
+	return ""
+}
+
+// normalizeDigest trims and lower cases a hex digest, returning "" if
+// it isn't one.
+func normalizeDigest(value string) string {
+	digest := strings.ToLower(strings.TrimSpace(value))
+	if digestType(digest) == "" {
+		return ""
+	}
+	return digest
+}
+
+// hashList adds the digests in hash lists to a filter, keeping count of
+// what happened to each line.
+type hashList struct {
+	// Digest is the hash algorithm of the list. When it is empty, it is
+	// set from the first digest read, and a list that goes on to mix in
+	// another type is refused.
+	Digest string
+	// Column is the CSV column holding digests, as for parseHashLine.
+	Column int
+
+	Accepted   int
+	Rejected   int
+	Duplicates int
+	// strict is set when Digest was taken from the list itself.
+	strict bool
+}
+
+// Read adds the digests in r to bf. Blank lines and # comments are
+// skipped. Lines without a digest, or with a digest of another type
+// than the list's when that was given up front, are rejected. Digests
+// that are already in the filter are counted as duplicates; since
+// filter lookups can give false positives, the duplicate count may be a
+// little high.
+func (l *hashList) Read(r io.Reader, name string, bf *BloomFilter) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digest := parseHashLine(line, l.Column)
+		if digest == "" {
+			l.Rejected++
+			continue
+		}
+		alg := digestType(digest)
+		if l.Digest == "" {
+			l.Digest = alg
+			l.strict = true
+			bf.Digest = alg
+		}
+		if alg != l.Digest {
+			if l.strict {
+				return fmt.Errorf(
+					"%s line %d: %s digest in a list of %s digests",
+					name,
+					lineNum,
+					alg,
+					l.Digest,
+				)
+			}
+			l.Rejected++
+			continue
+		}
+		if !bf.AddNew(digest) {
+			l.Duplicates++
+			continue
+		}
+		l.Accepted++
+	}
+	return scanner.Err()
+}
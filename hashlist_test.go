@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestParseHashLine(t *testing.T) {
+	md5Hex := "0cc175b9c0f1b6a831c399e269772661"
+	sha1Hex := "86f7e437faa5a7fce15d1ddcb9eaeaea377667b8"
+	tests := []struct {
+		line     string
+		column   int
+		expected string
+	}{
+		{"  0CC175B9C0F1B6A831C399E269772661  ", 0, md5Hex},
+		{md5Hex + "  wp-admin/index.php", 0, md5Hex},
+		{md5Hex + " *bin/file.exe", 0, md5Hex},
+		{md5Hex + "  uploads/invoice, final.pdf", 0, md5Hex},
+		{md5Hex + "  \"a,b\".php", 0, md5Hex},
+		{"MD5 (wp-login.php) = " + md5Hex, 0, md5Hex},
+		{"MD5 (a, b.php) = " + md5Hex, 0, md5Hex},
+		{md5Hex + ",a.php", 0, md5Hex},
+		{"wp-login.php," + sha1Hex + ",1234", 0, sha1Hex},
+		{"\"a, b.php\"," + md5Hex + "," + sha1Hex, 3, sha1Hex},
+		{"path,md5,sha1", 0, ""},
+		{strings.Repeat("x", 32), 0, ""},
+		{"0cc175b9", 0, ""},
+	}
+	for _, test := range tests {
+		actual := parseHashLine(test.line, test.column)
+		if actual != test.expected {
+			t.Errorf(
+				"parseHashLine: %q column %d: expected: %q actual: %q",
+				test.line,
+				test.column,
+				test.expected,
+				actual,
+			)
+		}
+	}
+}
+
+func TestHashListRead(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	lines := strings.Join([]string{
+		"# md5sum output",
+		"0cc175b9c0f1b6a831c399e269772661  a.txt",
+		"92eb5ffee6ae2fec3ad71c777531578f  b.txt",
+		"0CC175B9C0F1B6A831C399E269772661  copy-of-a.txt",
+		"",
+		"garbage",
+	}, "\n")
+
+	bloomFilter := NewScalableBloomFilter(10, 0.01, fs)
+	list := hashList{}
+	if err := list.Read(strings.NewReader(lines), "hashes.txt", &bloomFilter); err != nil {
+		t.Fatalf("hashList: Read: unexpected error: %v", err)
+	}
+	if list.Accepted != 2 || list.Rejected != 1 || list.Duplicates != 1 {
+		t.Errorf(
+			"hashList: Read: expected: 2 accepted 1 rejected 1 duplicate actual: %d %d %d",
+			list.Accepted,
+			list.Rejected,
+			list.Duplicates,
+		)
+	}
+	if bloomFilter.Digest != "md5" {
+		t.Errorf("hashList: Read: Digest: expected: md5 actual: %s", bloomFilter.Digest)
+	}
+
+	mixed := lines + "\n86f7e437faa5a7fce15d1ddcb9eaeaea377667b8  c.txt\n"
+	list = hashList{}
+	if err := list.Read(strings.NewReader(mixed), "mixed.txt", &bloomFilter); err == nil {
+		t.Errorf("hashList: Read: expected error for a list mixing md5 and sha1")
+	}
+
+	bloomFilter = NewScalableBloomFilter(10, 0.01, fs)
+	list = hashList{Digest: "sha1"}
+	if err := list.Read(strings.NewReader(mixed), "mixed.txt", &bloomFilter); err != nil {
+		t.Fatalf("hashList: Read: unexpected error with --digest: %v", err)
+	}
+	if list.Accepted != 1 || list.Rejected != 4 {
+		t.Errorf(
+			"hashList: Read: sha1: expected: 1 accepted 4 rejected actual: %d %d",
+			list.Accepted,
+			list.Rejected,
+		)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// identifyFPRate is the false positive rate filters are built for. A
+// filter must hold more than twice this share of the files the best
+// match doesn't before those files are taken as a sign of a mixed
+// install rather than chance.
+const identifyFPRate = 0.01
+
+// versionScore is how much of a scanned tree one filter holds.
+type versionScore struct {
+	Name string
+	// Matched counts the files the filter holds.
+	Matched int
+	// Elsewhere counts the files the filter holds that the best
+	// matching filter doesn't.
+	Elsewhere int
+}
+
+// Fraction returns the share of files scanned the filter holds.
+func (v versionScore) Fraction(files int) float64 {
+	if files == 0 {
+		return 0
+	}
+	return float64(v.Matched) / float64(files)
+}
+
+// identification is the result of scoring a tree against a set of
+// filters, such as one for each release of an application.
+type identification struct {
+	// Files counts the files scanned.
+	Files int
+	// Unmatched counts the files none of the filters hold.
+	Unmatched int
+	// Scores holds a score for each filter, best first.
+	Scores []versionScore
+	// Best counts the filters tied for the best score, which come first
+	// in Scores.
+	Best int
+	// Mixed lists the filters holding enough files that the best match
+	// doesn't to suggest files from other versions were mixed in.
+	Mixed []versionScore
+}
+
+// Identify scans paths and scores how much of what it finds each filter
+// holds, to tell which version of an application is installed.
+func (s *FilterSet) Identify(paths []string) identification {
+	var result identification
+	scores := make([]versionScore, len(s.Filters))
+	for i, bf := range s.Filters {
+		scores[i].Name = bf.Name
+	}
+	// The filters each file matched, by their index.
+	var matches [][]int
+	for _, path := range paths {
+		err := s.Scanner.Walk(path, func(f *scannedFile) error {
+			if f.Err != nil {
+				return nil
+			}
+			digests, err := s.digests(f)
+			if err != nil {
+				fmt.Printf("Error reading %s: %v\n", f.Path, err)
+				return nil
+			}
+			result.Files++
+			var matched []int
+			for i, bf := range s.Filters {
+				if bf.Contains(digestOf(bf, digests)) {
+					matched = append(matched, i)
+					scores[i].Matched++
+				}
+			}
+			if len(matched) == 0 {
+				result.Unmatched++
+				return nil
+			}
+			matches = append(matches, matched)
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]].Matched > scores[order[j]].Matched
+	})
+	if len(order) == 0 || scores[order[0]].Matched == 0 {
+		return result
+	}
+	best := order[0]
+	for _, i := range order {
+		if scores[i].Matched == scores[best].Matched {
+			result.Best++
+		}
+	}
+
+	// Files the best match doesn't hold point to other versions, if
+	// other filters hold more of them than chance would explain. Filters
+	// tied with the best holding different files point to them too.
+	candidates := result.Unmatched
+	for _, matched := range matches {
+		inBest := false
+		for _, i := range matched {
+			inBest = inBest || i == best
+		}
+		if inBest {
+			continue
+		}
+		candidates++
+		for _, i := range matched {
+			scores[i].Elsewhere++
+		}
+	}
+	threshold := int(2*identifyFPRate*float64(candidates)) + 1
+	for _, i := range order {
+		result.Scores = append(result.Scores, scores[i])
+		if scores[i].Elsewhere > threshold {
+			result.Mixed = append(result.Mixed, scores[i])
+		}
+	}
+	sort.SliceStable(result.Mixed, func(i, j int) bool {
+		return result.Mixed[i].Elsewhere > result.Mixed[j].Elsewhere
+	})
+	return result
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestIdentify(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	shared := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	releases := map[string][]string{
+		"wordpress-6.4": append([]string{"old1", "old2", "old3"}, shared...),
+		"wordpress-6.5": append([]string{"new1", "new2", "new3"}, shared...),
+	}
+	var filters []*BloomFilter
+	for _, name := range []string{"wordpress-6.4", "wordpress-6.5"} {
+		bf := NewBloomFilter(100, 0.01, fs)
+		bf.Name = name
+		for _, content := range releases[name] {
+			digest, _ := hashReader(strings.NewReader(content), "md5")
+			bf.Add(digest)
+		}
+		filters = append(filters, &bf)
+	}
+
+	tests := []struct {
+		name     string
+		contents []string
+		best     string
+		fraction string
+		mixed    string
+	}{
+		{"clean", append([]string{"new1", "new2", "new3", "upload"}, shared...), "wordpress-6.5", "0.93", ""},
+		{"mixed", append([]string{"old1", "old2", "old3", "new1", "new2", "new3", "new4"}, shared...), "wordpress-6.4", "0.76", "wordpress-6.5"},
+	}
+	for _, test := range tests {
+		site := "/var/www/" + test.name + "/"
+		fs.MkdirAll(site, 0755)
+		for i, content := range test.contents {
+			afero.WriteFile(fs, fmt.Sprintf("%sfile%d.php", site, i), []byte(content), 0644)
+		}
+		set := &FilterSet{Filters: filters, Scanner: NewScanner(fs)}
+		result := set.Identify([]string{site})
+		if result.Files != len(test.contents) || result.Scores[0].Name != test.best {
+			t.Errorf("FilterSet: Identify: %s: expected: %s actual: %v", test.name, test.best, result.Scores)
+			continue
+		}
+		if fraction := fmt.Sprintf("%.2f", result.Scores[0].Fraction(result.Files)); fraction != test.fraction {
+			t.Errorf("FilterSet: Identify: %s: expected fraction: %s actual: %s", test.name, test.fraction, fraction)
+		}
+		var mixed []string
+		for _, score := range result.Mixed {
+			mixed = append(mixed, score.Name)
+		}
+		if strings.Join(mixed, ",") != test.mixed {
+			t.Errorf("FilterSet: Identify: %s: expected mixed: %q actual: %v", test.name, test.mixed, mixed)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ignoreFileName is the name of the files holding exclusion rules for
+// the directory they are in and those below it.
+const ignoreFileName = ".mddignore"
+
+// ignoreRule is a gitignore-style pattern.
+type ignoreRule struct {
+	// base is the directory the pattern is relative to, as a slash
+	// separated path relative to the scan root, or "" for the root.
+	base string
+	re   *regexp.Regexp
+	// negate re-includes what an earlier rule excluded.
+	negate bool
+	// dirOnly matches only directories, for patterns ending in "/".
+	dirOnly bool
+	// anchored patterns hold a slash and match paths relative to base.
+	// Others match the name of a file or directory at any depth.
+	anchored bool
+	// source is the ignore file the rule was read from, or "" for rules
+	// given on the command line.
+	source string
+}
+
+// parseIgnoreRule parses a line of an ignore file or a pattern given on
+// the command line. It returns false for blank lines and comments, and
+// an error for patterns that can't be matched, such as [z-a].
+func parseIgnoreRule(line, base string) (ignoreRule, bool, error) {
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " \t\r")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false, nil
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false, nil
+	}
+	re, err := regexp.Compile("^" + globRegexp(line) + "$")
+	if err != nil {
+		return ignoreRule{}, false, fmt.Errorf("invalid pattern %q", line)
+	}
+	rule.re = re
+	return rule, true, nil
+}
+
+// globRegexp translates a gitignore glob into a regular expression.
+// "*" and "?" don't match "/", while "**" matches across directories.
+func globRegexp(pattern string) string {
+	var re strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					re.WriteString("(?:.*/)?")
+				} else {
+					re.WriteString(".*")
+				}
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
+
+// match reports whether the rule matches rel, a slash separated path
+// relative to the scan root.
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+	if !r.anchored {
+		rel = path.Base(rel)
+	}
+	return r.re.MatchString(rel)
+}
+
+// lastMatch returns the rule deciding whether rel is excluded: as in
+// git, the last one to match. It returns nil if none match.
+func lastMatch(rules []ignoreRule, rel string, isDir bool) *ignoreRule {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].match(rel, isDir) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// matchRules applies rules in order, as git does: the last one to match
+// decides. It returns whether rel is excluded, and whether any rule
+// matched at all.
+func matchRules(rules []ignoreRule, rel string, isDir bool) (excluded, matched bool) {
+	if rule := lastMatch(rules, rel, isDir); rule != nil {
+		return !rule.negate, true
+	}
+	return false, false
+}
+
+// readIgnoreFile reads the rules in an ignore file. A missing file has
+// no rules. Invalid patterns are skipped, and printed to report if it
+// is set.
+func readIgnoreFile(fs afero.Fs, file, base string, report io.Writer) []ignoreRule {
+	f, err := fs.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		rule, ok, err := parseIgnoreRule(scanner.Text(), base)
+		if err != nil && report != nil {
+			fmt.Fprintf(report, "[-] %s line %d: skipping %v\n", file, lineNum, err)
+		}
+		if ok {
+			rule.source = file
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// scanFilter decides which files a Scanner reports.
+type scanFilter struct {
+	// Excludes are gitignore-style rules, which take precedence over
+	// those in ignore files.
+	Excludes []ignoreRule
+	// Includes, when there are any, limit the scan to files matching
+	// at least one of them.
+	Includes []ignoreRule
+	// IgnoreFile is the name of the ignore files read during a scan,
+	// or "" to read none. Since ignore files come from the tree being
+	// scanned, anyone able to write there could hide files with them,
+	// so they are only read when asked for.
+	IgnoreFile string
+	// MinSize and MaxSize bound file sizes. A MaxSize of 0 means no
+	// limit.
+	MinSize int64
+	MaxSize int64
+	// Newer and Older bound modification times, when set.
+	Newer time.Time
+	Older time.Time
+	// Extensions, when there are any, limit the scan to files with one
+	// of these extensions, given without the leading dot.
+	Extensions []string
+}
+
+// keepFile applies the checks that don't depend on a file's path
+// within the scan. Sizes are only asked for when bounded, since some
+// files, such as git blobs, have to be read to learn theirs.
+func (f *scanFilter) keepFile(info os.FileInfo) bool {
+	if f.MinSize > 0 || f.MaxSize > 0 {
+		size := info.Size()
+		if size < f.MinSize || f.MaxSize > 0 && size > f.MaxSize {
+			return false
+		}
+	}
+	if !f.Newer.IsZero() && !info.ModTime().After(f.Newer) {
+		return false
+	}
+	if !f.Older.IsZero() && !info.ModTime().Before(f.Older) {
+		return false
+	}
+	if len(f.Extensions) > 0 {
+		ext := strings.TrimPrefix(filepath.Ext(info.Name()), ".")
+		for _, want := range f.Extensions {
+			if strings.EqualFold(ext, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ignoreSet holds the rules in effect during one scan, reading ignore
+// files as directories are reached.
+type ignoreSet struct {
+	fs     afero.Fs
+	root   string
+	filter *scanFilter
+	// report, when set, is where the ignore files read, and problems
+	// with them, are printed.
+	report io.Writer
+	// dirs holds the rules from the ignore files of each directory and
+	// its parents, by path relative to the root.
+	dirs map[string][]ignoreRule
+	// sources lists the ignore files read, in order, and skipped counts
+	// the files and directories each one excluded.
+	sources []string
+	skipped map[string]int
+}
+
+func newIgnoreSet(fs afero.Fs, root string, filter *scanFilter, report io.Writer) *ignoreSet {
+	return &ignoreSet{
+		fs:      fs,
+		root:    root,
+		filter:  filter,
+		report:  report,
+		dirs:    make(map[string][]ignoreRule),
+		skipped: make(map[string]int),
+	}
+}
+
+// rulesFor returns the rules from the ignore files that apply inside
+// dir, a slash separated path relative to the root.
+func (s *ignoreSet) rulesFor(dir string) []ignoreRule {
+	if rules, ok := s.dirs[dir]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	if dir != "" {
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		rules = append(rules, s.rulesFor(parent)...)
+	}
+	if s.filter.IgnoreFile != "" {
+		file := filepath.Join(s.root, filepath.FromSlash(dir), s.filter.IgnoreFile)
+		if _, err := s.fs.Stat(file); err == nil {
+			s.sources = append(s.sources, file)
+			if s.report != nil {
+				fmt.Fprintf(s.report, "[!] Reading exclusion rules from %s\n", file)
+			}
+		}
+		rules = append(rules, readIgnoreFile(s.fs, file, dir, s.report)...)
+	}
+	s.dirs[dir] = rules
+	return rules
+}
+
+// excluded reports whether the rules exclude rel.
+func (s *ignoreSet) excluded(rel string, isDir bool) bool {
+	excluded, matched := matchRules(s.filter.Excludes, rel, isDir)
+	if !matched {
+		parent := path.Dir(rel)
+		if parent == "." {
+			parent = ""
+		}
+		if rule := lastMatch(s.rulesFor(parent), rel, isDir); rule != nil && !rule.negate {
+			excluded = true
+			s.skipped[rule.source]++
+		}
+	}
+	if excluded || isDir || len(s.filter.Includes) == 0 {
+		return excluded
+	}
+	included, _ := matchRules(s.filter.Includes, rel, false)
+	return !included
+}
+
+// printSkipped prints how many files and directories each ignore file
+// read excluded, if report is set, so that nothing is left out of a
+// scan unnoticed.
+func (s *ignoreSet) printSkipped() {
+	if s.report == nil {
+		return
+	}
+	for _, source := range s.sources {
+		fmt.Fprintf(s.report, "[!] %s excluded %d files and directories\n", source, s.skipped[source])
+	}
+}
+
+// parseByteSize parses a size in bytes, with an optional K, M or G
+// suffix for powers of 1024.
+func parseByteSize(value string) (int64, error) {
+	multiplier := int64(1)
+	upper := strings.TrimSuffix(strings.ToUpper(value), "B")
+	for i, suffix := range []string{"K", "M", "G"} {
+		if strings.HasSuffix(upper, suffix) {
+			multiplier = 1 << (10 * uint(i+1))
+			upper = strings.TrimSuffix(upper, suffix)
+		}
+	}
+	size, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// parseWhen parses a point in time given as a date, an RFC 3339
+// timestamp, or an age such as 12h or 30d counted back from now.
+func parseWhen(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if when, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return when, nil
+		}
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil && age >= 0 {
+		return now.Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
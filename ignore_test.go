@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestIgnoreRuleMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+		rel     string
+		isDir   bool
+		match   bool
+	}{
+		{"*.log", "", "error.log", false, true},
+		{"*.log", "", "var/log/error.log", false, true},
+		{"*.log", "", "error.log.gz", false, false},
+		{"cache/", "", "wp-content/cache", true, true},
+		{"cache/", "", "wp-content/cache", false, false},
+		{"/uploads", "", "uploads", true, true},
+		{"/uploads", "", "wp-content/uploads", true, false},
+		{"wp-content/uploads", "", "wp-content/uploads", true, true},
+		{"uploads", "wp-content", "wp-content/uploads", true, true},
+		{"/uploads", "wp-content", "uploads", true, false},
+		{"**/tmp", "", "a/b/tmp", true, true},
+		{"**/tmp", "", "tmp", true, true},
+		{"logs/**", "", "logs/2024/01.txt", false, true},
+		{"a/**/b", "", "a/x/y/b", false, true},
+		{"a/**/b", "", "a/b", false, true},
+		{"file?.txt", "", "file1.txt", false, true},
+		{"file[0-9].txt", "", "filea.txt", false, false},
+		{"file[!0-9].txt", "", "filea.txt", false, true},
+		{`\#notes`, "", "#notes", false, true},
+	}
+	for _, test := range tests {
+		rule, ok, err := parseIgnoreRule(test.pattern, test.base)
+		if !ok || err != nil {
+			t.Errorf("parseIgnoreRule: %q: expected a rule", test.pattern)
+			continue
+		}
+		if match := rule.match(test.rel, test.isDir); match != test.match {
+			t.Errorf(
+				"ignoreRule: match %q against %q: expected: %v actual: %v",
+				test.pattern,
+				test.rel,
+				test.match,
+				match,
+			)
+		}
+	}
+	for _, line := range []string{"", "   ", "# comment"} {
+		if _, ok, _ := parseIgnoreRule(line, ""); ok {
+			t.Errorf("parseIgnoreRule: %q: expected no rule", line)
+		}
+	}
+	for _, line := range []string{"[z-a]", "file[[:nope:]]"} {
+		if _, ok, err := parseIgnoreRule(line, ""); ok || err == nil {
+			t.Errorf("parseIgnoreRule: %q: expected an error", line)
+		}
+	}
+}
+
+func TestScanFilter(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/www/"
+	fs.MkdirAll(fakeDir, 0755)
+	files := map[string]string{
+		"index.php":                      "<?php",
+		"debug.log":                      "log",
+		".mddignore":                     "*.log\ncache/\n[z-a]\n",
+		"wp-content/cache/page.html":     "cached",
+		"wp-content/uploads/.mddignore":  "*\n!*.php\n",
+		"wp-content/uploads/photo.jpg":   "jpeg",
+		"wp-content/uploads/shell.php":   "<?php system($_GET['c']);",
+		"wp-content/themes/style.css":    "body {}",
+		"wp-content/themes/big-file.css": strings.Repeat("x", 4096),
+	}
+	for _, dir := range []string{"wp-content", "wp-content/cache", "wp-content/uploads", "wp-content/themes"} {
+		fs.MkdirAll(fakeDir+dir, 0755)
+	}
+	for name, content := range files {
+		afero.WriteFile(fs, fakeDir+name, []byte(content), 0644)
+	}
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs.Chtimes(fakeDir+"wp-content/themes/style.css", old, old)
+
+	scan := func(scanner *Scanner) []string {
+		var paths []string
+		scanner.Walk(fakeDir, func(f *scannedFile) error {
+			paths = append(paths, f.Rel)
+			return nil
+		})
+		sort.Strings(paths)
+		if count := scanner.Count(fakeDir); int(count) != len(paths) {
+			t.Errorf("Scanner: Count: expected: %d actual: %d", len(paths), count)
+		}
+		return paths
+	}
+
+	tests := []struct {
+		name     string
+		filter   func(*scanFilter)
+		expected []string
+	}{
+		{
+			"ignore files",
+			func(f *scanFilter) {
+				f.IgnoreFile = ignoreFileName
+			},
+			[]string{
+				".mddignore",
+				"index.php",
+				"wp-content/themes/big-file.css",
+				"wp-content/themes/style.css",
+				"wp-content/uploads/shell.php",
+			},
+		},
+		{
+			"exclude overrides ignore files",
+			func(f *scanFilter) {
+				f.IgnoreFile = ignoreFileName
+				rule, _, _ := parseIgnoreRule("!debug.log", "")
+				themes, _, _ := parseIgnoreRule("themes/", "")
+				f.Excludes = []ignoreRule{rule, themes}
+			},
+			[]string{".mddignore", "debug.log", "index.php", "wp-content/uploads/shell.php"},
+		},
+		{
+			"include",
+			func(f *scanFilter) {
+				rule, _, _ := parseIgnoreRule("*.css", "")
+				f.Includes = []ignoreRule{rule}
+			},
+			[]string{"wp-content/themes/big-file.css", "wp-content/themes/style.css"},
+		},
+		{
+			"extension and size",
+			func(f *scanFilter) {
+				f.Extensions = []string{"css", "PHP"}
+				f.MaxSize = 1024
+			},
+			[]string{"index.php", "wp-content/themes/style.css", "wp-content/uploads/shell.php"},
+		},
+		{
+			"modification time",
+			func(f *scanFilter) {
+				f.Older = old.Add(time.Hour)
+			},
+			[]string{"wp-content/themes/style.css"},
+		},
+		{
+			"ignore files not read by default",
+			func(f *scanFilter) {
+				f.MinSize = 5
+			},
+			[]string{
+				".mddignore",
+				"index.php",
+				"wp-content/cache/page.html",
+				"wp-content/themes/big-file.css",
+				"wp-content/themes/style.css",
+				"wp-content/uploads/.mddignore",
+				"wp-content/uploads/shell.php",
+			},
+		},
+	}
+	for _, test := range tests {
+		scanner := NewScanner(fs)
+		test.filter(&scanner.Filter)
+		paths := scan(scanner)
+		if strings.Join(paths, " ") != strings.Join(test.expected, " ") {
+			t.Errorf(
+				"Scanner: %s: expected: %v actual: %v",
+				test.name,
+				test.expected,
+				paths,
+			)
+		}
+	}
+
+	// Without size bounds, sizes that are costly to learn aren't asked for.
+	sized := 0
+	info := gitFileInfo{name: "blob.php", mode: 0644, size: func() int64 {
+		sized++
+		return 10
+	}}
+	filter := scanFilter{Extensions: []string{"php"}}
+	if !filter.keepFile(info) || sized != 0 {
+		t.Errorf("scanFilter: keepFile: expected no size lookups actual: %d", sized)
+	}
+	filter.MaxSize = 5
+	if filter.keepFile(info) || sized != 1 {
+		t.Errorf("scanFilter: keepFile: expected the size bound to be applied")
+	}
+}
+
+func TestParseByteSizeAndWhen(t *testing.T) {
+	for value, expected := range map[string]int64{"100": 100, "4k": 4096, "2MB": 2 << 20, "1G": 1 << 30} {
+		if size, err := parseByteSize(value); err != nil || size != expected {
+			t.Errorf("parseByteSize: %s: expected: %d actual: %d (%v)", value, expected, size, err)
+		}
+	}
+	if _, err := parseByteSize("lots"); err == nil {
+		t.Errorf("parseByteSize: expected an error for an invalid size")
+	}
+
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Time{
+		"30d":                  now.AddDate(0, 0, -30),
+		"12h":                  now.Add(-12 * time.Hour),
+		"2024-01-02T03:04:05Z": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	} {
+		if when, err := parseWhen(value, now); err != nil || !when.Equal(expected) {
+			t.Errorf("parseWhen: %s: expected: %v actual: %v (%v)", value, expected, when, err)
+		}
+	}
+}
+
+func TestIgnoreSetSkipped(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/www/"
+	fs.MkdirAll(fakeDir+"uploads", 0755)
+	afero.WriteFile(fs, fakeDir+"uploads/.mddignore", []byte("*\n!*.jpg\n"), 0644)
+
+	filter := scanFilter{IgnoreFile: ignoreFileName}
+	ignores := newIgnoreSet(fs, fakeDir, &filter, nil)
+	for _, rel := range []string{"index.php", "uploads/shell.php", "uploads/cmd.php", "uploads/photo.jpg"} {
+		ignores.excluded(rel, false)
+	}
+	source := fakeDir + "uploads/" + ignoreFileName
+	if len(ignores.sources) != 1 || ignores.sources[0] != source {
+		t.Errorf("ignoreSet: sources: expected: [%s] actual: %v", source, ignores.sources)
+	}
+	if ignores.skipped[source] != 2 {
+		t.Errorf("ignoreSet: skipped: expected: 2 actual: %d", ignores.skipped[source])
+	}
+}
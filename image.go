@@ -0,0 +1,370 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/ulikunitz/xz"
+)
+
+// Whiteout files mark paths deleted by an image layer. An opaque
+// whiteout hides everything lower layers put in its directory.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// imageSource reads the blobs of an OCI image layout, either a
+// directory or a tarball such as `docker save` writes.
+type imageSource struct {
+	fs    afero.Fs
+	path  string
+	isDir bool
+	// members locates the members of an uncompressed tarball, so each
+	// can be read without reading through the ones before it.
+	members map[string]tarMember
+}
+
+// tarMember is where a member's content lies in a tarball.
+type tarMember struct {
+	offset int64
+	size   int64
+}
+
+// newImageSource opens the image at file. A tarball is indexed in one
+// pass over its headers, unless it is compressed, in which case each
+// blob is found by reading through it again.
+func newImageSource(file string, fs afero.Fs) (*imageSource, error) {
+	info, err := fs.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	s := &imageSource{fs: fs, path: file, isDir: info.IsDir()}
+	if s.isDir {
+		return s, nil
+	}
+	f, err := fs.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+	if archiveKind(header[:n]) != "tar" {
+		return s, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// Member contents are skipped by seeking, so only headers are read.
+	archive := tar.NewReader(f)
+	s.members = make(map[string]tarMember)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		s.members[path.Clean(header.Name)] = tarMember{offset, header.Size}
+	}
+}
+
+// open opens the named blob or metadata file of the image.
+func (s *imageSource) open(name string) (io.ReadCloser, error) {
+	if s.isDir {
+		return s.fs.Open(filepath.Join(s.path, filepath.FromSlash(name)))
+	}
+	if s.members != nil {
+		member, ok := s.members[path.Clean(name)]
+		if !ok {
+			return nil, fmt.Errorf("%s: %s not found", s.path, name)
+		}
+		f, err := s.fs.Open(s.path)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(f, member.offset, member.size), f}, nil
+	}
+	input, err := openInput(s.path, s.fs)
+	if err != nil {
+		return nil, err
+	}
+	archive := tar.NewReader(input)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			input.Close()
+			if err == io.EOF {
+				return nil, fmt.Errorf("%s: %s not found", s.path, name)
+			}
+			return nil, err
+		}
+		if path.Clean(header.Name) == path.Clean(name) {
+			return struct {
+				io.Reader
+				io.Closer
+			}{archive, input}, nil
+		}
+	}
+}
+
+// readJSON decodes the named metadata file of the image into v.
+func (s *imageSource) readJSON(name string, v interface{}) error {
+	f, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+// dockerManifest is an entry in the manifest.json of a `docker save`
+// tarball.
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// ociDescriptor points to a blob in an OCI image layout.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// ociManifest is an OCI image index or image manifest. An index lists
+// manifests; a manifest lists layers.
+type ociManifest struct {
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// blobPath returns the path of a blob in an OCI image layout.
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// layers returns the paths of the image's layers, lowest first. When
+// an image holds several images or platforms, the first is used.
+func (s *imageSource) layers() ([]string, error) {
+	var manifests []dockerManifest
+	if err := s.readJSON("manifest.json", &manifests); err == nil {
+		if len(manifests) == 0 {
+			return nil, fmt.Errorf("%s: manifest.json lists no images", s.path)
+		}
+		return manifests[0].Layers, nil
+	}
+
+	var manifest ociManifest
+	if err := s.readJSON("index.json", &manifest); err != nil {
+		return nil, fmt.Errorf("%s: not an OCI image layout or docker save tarball", s.path)
+	}
+	// Indexes may point to further indexes before reaching a manifest.
+	for depth := 0; len(manifest.Layers) == 0; depth++ {
+		if len(manifest.Manifests) == 0 || depth > 8 {
+			return nil, fmt.Errorf("%s: no image manifest found", s.path)
+		}
+		digest := manifest.Manifests[0].Digest
+		manifest = ociManifest{}
+		if err := s.readJSON(blobPath(digest), &manifest); err != nil {
+			return nil, err
+		}
+	}
+	var layers []string
+	for _, layer := range manifest.Layers {
+		layers = append(layers, blobPath(layer.Digest))
+	}
+	return layers, nil
+}
+
+// openLayer opens a layer for reading as a tar archive, decompressing
+// it if need be.
+func (s *imageSource) openLayer(name string) (*tar.Reader, io.Closer, error) {
+	f, err := s.open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered := bufio.NewReaderSize(f, 1024)
+	header, _ := buffered.Peek(512)
+	var r io.Reader
+	switch archiveKind(header) {
+	case "tar":
+		r = buffered
+	case "gzip":
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r = gz
+	case "bzip2":
+		r = bzip2.NewReader(buffered)
+	case "xz":
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r = xzReader
+	default:
+		f.Close()
+		return nil, nil, fmt.Errorf("layer %s: unsupported compression", name)
+	}
+	return tar.NewReader(r), f, nil
+}
+
+// layerCursor is a layer being read through. Files are usually opened
+// in about the order they were stored, so reading carries on from
+// where the last file was found rather than starting over. Each layer
+// has its own cursor, so files taken from different layers in turn
+// don't start each other over.
+type layerCursor struct {
+	source  *imageSource
+	layer   string
+	archive *tar.Reader
+	closer  io.Closer
+	// next is the index of the member archive.Next returns next.
+	next int
+	// member is the member last opened, the only one that can be read.
+	member *layerMember
+}
+
+// open returns a stream of the content of the member at index in the
+// layer, which can be read until another member of it is opened.
+func (c *layerCursor) open(index int) (io.ReadCloser, error) {
+	if c.archive == nil || c.next > index {
+		c.close()
+		archive, closer, err := c.source.openLayer(c.layer)
+		if err != nil {
+			return nil, err
+		}
+		c.archive, c.closer, c.next = archive, closer, 0
+	}
+	for ; c.next <= index; c.next++ {
+		if _, err := c.archive.Next(); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	c.member = &layerMember{cursor: c}
+	return c.member, nil
+}
+
+func (c *layerCursor) close() {
+	if c.closer != nil {
+		c.closer.Close()
+	}
+	c.archive, c.closer, c.member = nil, nil, nil
+}
+
+// layerMember streams a member of the layer a cursor is reading.
+type layerMember struct {
+	cursor *layerCursor
+}
+
+func (m *layerMember) Read(p []byte) (int, error) {
+	if m.cursor.member != m {
+		return 0, errors.New("another file of the layer was opened")
+	}
+	return m.cursor.archive.Read(p)
+}
+
+func (m *layerMember) Close() error {
+	return nil
+}
+
+// NewImageFs reads the image at path, an OCI image layout directory or
+// a `docker save` tarball, and returns a read-only filesystem holding
+// the files of the image with its layers applied in order. File
+// contents are read from the layers only when opened.
+func NewImageFs(path string, fs afero.Fs) (afero.Fs, error) {
+	source, err := newImageSource(path, fs)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := source.layers()
+	if err != nil {
+		return nil, err
+	}
+	image := newIndexFs(path)
+	for depth, layer := range layers {
+		if err := applyLayer(image, source, layer, depth); err != nil {
+			return nil, fmt.Errorf("layer %s: %v", layer, err)
+		}
+	}
+	return image, nil
+}
+
+// layerEntry records which layer a file or directory came from, so
+// opaque whiteouts can tell what lower layers added.
+type layerEntry struct {
+	os.FileInfo
+	depth int
+}
+
+// applyLayer adds the files of a layer to image, removing those hidden
+// by its whiteouts.
+func applyLayer(image *indexFs, source *imageSource, layer string, depth int) error {
+	archive, closer, err := source.openLayer(layer)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	cursor := &layerCursor{source: source, layer: layer}
+	for index := 0; ; index++ {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := indexPath(header.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			image.removeChildren(dir, func(entry *indexEntry) bool {
+				fromLayer, ok := entry.info.(layerEntry)
+				return !ok || fromLayer.depth < depth
+			})
+		case strings.HasPrefix(base, whiteoutPrefix):
+			image.remove(dir + strings.TrimPrefix(base, whiteoutPrefix))
+		case header.Typeflag == tar.TypeLink:
+			target, ok := image.lookup(header.Linkname)
+			if !ok {
+				continue
+			}
+			info := layerEntry{renamedInfo{target.info, path.Base(name)}, depth}
+			image.add(name, &indexEntry{info: info, open: target.open})
+		default:
+			entry := &indexEntry{info: layerEntry{header.FileInfo(), depth}}
+			if entry.info.Mode().IsRegular() {
+				memberIndex := index
+				entry.open = func() (io.ReadCloser, error) {
+					return cursor.open(memberIndex)
+				}
+			}
+			image.add(name, entry)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// layerFile is a member of a test image layer. A Link makes a hard
+// link, and names ending in / are directories.
+type layerFile struct {
+	Name    string
+	Content string
+	Link    string
+}
+
+func makeLayer(files ...layerFile) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, file := range files {
+		header := &tar.Header{Name: file.Name, Mode: 0644, Size: int64(len(file.Content))}
+		switch {
+		case file.Link != "":
+			header.Typeflag = tar.TypeLink
+			header.Linkname = file.Link
+			header.Size = 0
+		case strings.HasSuffix(file.Name, "/"):
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		default:
+			header.Typeflag = tar.TypeReg
+		}
+		tw.WriteHeader(header)
+		tw.Write([]byte(file.Content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// testLayers are two layers, the second of which deletes /etc/hosts,
+// hides the old contents of /app, though not what it adds there
+// itself, and replaces /etc/passwd.
+var testLayers = [][]byte{
+	makeLayer(
+		layerFile{Name: "etc/"},
+		layerFile{Name: "etc/hosts", Content: "hosts"},
+		layerFile{Name: "etc/passwd", Content: "root"},
+		layerFile{Name: "app/old.js", Content: "old"},
+		layerFile{Name: "bin/sh", Content: "sh"},
+	),
+	makeLayer(
+		layerFile{Name: "app/"},
+		layerFile{Name: "app/lib/util.js", Content: "util"},
+		layerFile{Name: "app/.wh..wh..opq"},
+		layerFile{Name: "app/new.js", Content: "new"},
+		layerFile{Name: "etc/.wh.hosts"},
+		layerFile{Name: "etc/passwd", Content: "root\nuser"},
+		layerFile{Name: "bin/bash", Link: "bin/sh"},
+	),
+}
+
+var testImageFiles = []string{
+	"/app/lib/util.js",
+	"/app/new.js",
+	"/bin/bash",
+	"/bin/sh",
+	"/etc/passwd",
+}
+
+func imageFiles(fs afero.Fs) ([]string, map[string]string) {
+	var paths []string
+	contents := make(map[string]string)
+	afero.Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := afero.ReadFile(fs, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		contents[path] = string(content)
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, contents
+}
+
+func checkImageFs(t *testing.T, kind string, image afero.Fs) {
+	paths, contents := imageFiles(image)
+	if strings.Join(paths, " ") != strings.Join(testImageFiles, " ") {
+		t.Errorf(
+			"NewImageFs: %s: expected: %v actual: %v",
+			kind,
+			testImageFiles,
+			paths,
+		)
+	}
+	if contents["/etc/passwd"] != "root\nuser" {
+		t.Errorf("NewImageFs: %s: expected upper /etc/passwd: %q", kind, contents["/etc/passwd"])
+	}
+	if contents["/bin/bash"] != "sh" {
+		t.Errorf("NewImageFs: %s: expected hard link content: %q", kind, contents["/bin/bash"])
+	}
+	if _, err := image.Create("/tmp/x"); err == nil {
+		t.Errorf("NewImageFs: %s: expected a read-only filesystem", kind)
+	}
+}
+
+func TestDockerSaveImage(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+
+	manifest, _ := json.Marshal([]dockerManifest{{
+		Config:   "config.json",
+		RepoTags: []string{"app:latest"},
+		Layers:   []string{"one/layer.tar", "two/layer.tar"},
+	}})
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, member := range []struct {
+		name    string
+		content []byte
+	}{
+		{"one/layer.tar", testLayers[0]},
+		{"two/layer.tar", testLayers[1]},
+		{"manifest.json", manifest},
+	} {
+		tw.WriteHeader(&tar.Header{Name: member.name, Mode: 0644, Size: int64(len(member.content))})
+		tw.Write(member.content)
+	}
+	tw.Close()
+	afero.WriteFile(fs, fakeDir+"app.tar", buf.Bytes(), 0644)
+	afero.WriteFile(fs, fakeDir+"app.tar.gz", makeGzip(buf.Bytes()), 0644)
+
+	for _, name := range []string{"app.tar", "app.tar.gz"} {
+		image, err := NewImageFs(fakeDir+name, fs)
+		if err != nil {
+			t.Fatalf("NewImageFs: docker save %s: unexpected error: %v", name, err)
+		}
+		checkImageFs(t, "docker save "+name, image)
+	}
+
+	// Files from different layers can be read side by side.
+	image, _ := NewImageFs(fakeDir+"app.tar", fs)
+	sh, _ := image.Open("/bin/sh")
+	defer sh.Close()
+	start := make([]byte, 1)
+	io.ReadFull(sh, start)
+	if content, _ := afero.ReadFile(image, "/app/new.js"); string(content) != "new" {
+		t.Errorf("NewImageFs: expected: new actual: %q", content)
+	}
+	if rest, err := ioutil.ReadAll(sh); err != nil || string(rest) != "h" {
+		t.Errorf("indexFile: Read: expected: h actual: %q, %v", rest, err)
+	}
+
+	source, err := newImageSource(fakeDir+"app.tar", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(source.members) != 3 {
+		t.Errorf("newImageSource: expected: 3 members indexed actual: %d", len(source.members))
+	}
+	f, err := source.open("two/layer.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(f)
+	f.Close()
+	if !bytes.Equal(content, testLayers[1]) {
+		t.Errorf("imageSource: open: expected the second layer")
+	}
+}
+
+func TestImageFileStream(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	layout := "/var/data/app-oci/"
+	fs.MkdirAll(layout+"blobs/sha256", 0755)
+	layer := makeGzip(makeLayer(
+		layerFile{Name: "a.txt", Content: "first file"},
+		layerFile{Name: "b.txt", Content: "second file"},
+	))
+	sum := sha256.Sum256(layer)
+	layerDigest := "sha256:" + hex.EncodeToString(sum[:])
+	afero.WriteFile(fs, layout+blobPath(layerDigest), layer, 0644)
+	manifest, _ := json.Marshal(ociManifest{Layers: []ociDescriptor{{Digest: layerDigest}}})
+	sum = sha256.Sum256(manifest)
+	manifestDigest := "sha256:" + hex.EncodeToString(sum[:])
+	afero.WriteFile(fs, layout+blobPath(manifestDigest), manifest, 0644)
+	index, _ := json.Marshal(ociManifest{Manifests: []ociDescriptor{{Digest: manifestDigest}}})
+	afero.WriteFile(fs, layout+"index.json", index, 0644)
+
+	image, err := NewImageFs(layout, fs)
+	if err != nil {
+		t.Fatalf("NewImageFs: unexpected error: %v", err)
+	}
+	a, _ := image.Open("/a.txt")
+	defer a.Close()
+	start := make([]byte, 6)
+	io.ReadFull(a, start)
+	b, _ := image.Open("/b.txt")
+	defer b.Close()
+	if content, _ := ioutil.ReadAll(b); string(content) != "second file" {
+		t.Errorf("indexFile: Read: expected: second file actual: %q", content)
+	}
+	if _, err := a.Read(start); err == nil {
+		t.Errorf("indexFile: Read: expected an error reading a stream after another file was opened")
+	}
+	rest := make([]byte, 4)
+	if n, err := a.ReadAt(rest, 6); err != nil || string(rest[:n]) != "file" {
+		t.Errorf("indexFile: ReadAt: expected: file actual: %q, %v", rest[:n], err)
+	}
+	if _, err := a.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadAll(a); string(content) != "first file" {
+		t.Errorf("indexFile: Seek: expected: first file actual: %q", content)
+	}
+}
+
+func TestOCIImageLayout(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	layout := "/var/data/app-oci/"
+	fs.MkdirAll(layout+"blobs/sha256", 0755)
+
+	writeBlob := func(content []byte) string {
+		sum := sha256.Sum256(content)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		afero.WriteFile(fs, layout+blobPath(digest), content, 0644)
+		return digest
+	}
+	var layers []ociDescriptor
+	for _, layer := range testLayers {
+		layers = append(layers, ociDescriptor{
+			MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+			Digest:    writeBlob(makeGzip(layer)),
+		})
+	}
+	manifest, _ := json.Marshal(ociManifest{Layers: layers})
+	index, _ := json.Marshal(ociManifest{Manifests: []ociDescriptor{{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Digest:    writeBlob(manifest),
+	}}})
+	afero.WriteFile(fs, layout+"index.json", index, 0644)
+	afero.WriteFile(fs, layout+"oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+
+	image, err := NewImageFs(layout, fs)
+	if err != nil {
+		t.Fatalf("NewImageFs: OCI layout: unexpected error: %v", err)
+	}
+	checkImageFs(t, "OCI layout", image)
+
+	fakeFilterDir := "/tmp/filters/"
+	fs.MkdirAll(fakeFilterDir, 0755)
+	args := []string{"mdd", "calculate", "--image", layout, fakeFilterDir + "filterfile"}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Calculate()
+
+	bloomFilter := NewBloomFilter(1, 0.01, fs)
+	bloomFilter.Load(fakeFilterDir + "filterfile")
+	for _, content := range []string{"new", "root\nuser"} {
+		digest, _ := hashReader(strings.NewReader(content), "md5")
+		if !bloomFilter.Lookup(digest) {
+			t.Errorf("Calculate: --image: expected to find digest of %q", content)
+		}
+	}
+	digest, _ := hashReader(strings.NewReader("hosts"), "md5")
+	if bloomFilter.Lookup(digest) {
+		t.Errorf("Calculate: --image: expected whited out /etc/hosts to be skipped")
+	}
+}
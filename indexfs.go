@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// indexEntry is a file or directory in an indexFs. Content is read
+// only when the file is opened, and streamed from open as it is read.
+type indexEntry struct {
+	info os.FileInfo
+	open func() (io.ReadCloser, error)
+}
+
+// indexFs is a read-only afero.Fs over files listed in an index, such
+// as the merged layers of a container image. Paths are slash separated
+// and rooted at "/".
+type indexFs struct {
+	name    string
+	entries map[string]*indexEntry
+	// children holds the base names of the entries in each directory,
+	// so whiteouts only visit what they remove.
+	children map[string]map[string]bool
+}
+
+// newIndexFs constructs an empty indexFs holding just the root.
+func newIndexFs(name string) *indexFs {
+	fs := &indexFs{
+		name:     name,
+		entries:  make(map[string]*indexEntry),
+		children: make(map[string]map[string]bool),
+	}
+	fs.entries["/"] = &indexEntry{info: indexDirInfo{name: "/"}}
+	return fs
+}
+
+// indexPath cleans a path into the form used as a key in an indexFs.
+func indexPath(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// add records a file or directory, creating any missing parent
+// directories. A file that replaces a directory takes the directory's
+// contents with it.
+func (fs *indexFs) add(name string, entry *indexEntry) {
+	name = indexPath(name)
+	for child := name; child != "/"; child = path.Dir(child) {
+		dir := path.Dir(child)
+		if fs.children[dir] == nil {
+			fs.children[dir] = make(map[string]bool)
+		}
+		fs.children[dir][path.Base(child)] = true
+		if parent, ok := fs.entries[dir]; ok && parent.info.IsDir() {
+			break
+		}
+		fs.entries[dir] = &indexEntry{info: indexDirInfo{name: path.Base(dir)}}
+	}
+	if old, ok := fs.entries[name]; ok && old.info.IsDir() && !entry.info.IsDir() {
+		fs.removeChildren(name, func(*indexEntry) bool { return true })
+	}
+	fs.entries[name] = entry
+}
+
+// remove deletes a file or directory and anything below it.
+func (fs *indexFs) remove(name string) {
+	name = indexPath(name)
+	if _, ok := fs.entries[name]; !ok || name == "/" {
+		return
+	}
+	fs.removeChildren(name, func(*indexEntry) bool { return true })
+	delete(fs.entries, name)
+	delete(fs.children, name)
+	delete(fs.children[path.Dir(name)], path.Base(name))
+}
+
+// removeChildren deletes the entries below dir for which match returns
+// true. Directories still holding entries that weren't removed are kept.
+func (fs *indexFs) removeChildren(dir string, match func(*indexEntry) bool) {
+	dir = indexPath(dir)
+	for base := range fs.children[dir] {
+		name := path.Join(dir, base)
+		fs.removeChildren(name, match)
+		if match(fs.entries[name]) && len(fs.children[name]) == 0 {
+			delete(fs.entries, name)
+			delete(fs.children[dir], base)
+			delete(fs.children, name)
+		}
+	}
+}
+
+// lookup returns the entry for name, if there is one.
+func (fs *indexFs) lookup(name string) (*indexEntry, bool) {
+	entry, ok := fs.entries[indexPath(name)]
+	return entry, ok
+}
+
+// list returns the sorted names of the entries in dir.
+func (fs *indexFs) list(dir string) []string {
+	var names []string
+	for name := range fs.children[dir] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readOnly(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: syscall.EROFS}
+}
+
+func (fs *indexFs) Create(name string) (afero.File, error) {
+	return nil, readOnly("create", name)
+}
+
+func (fs *indexFs) Mkdir(name string, perm os.FileMode) error {
+	return readOnly("mkdir", name)
+}
+
+func (fs *indexFs) MkdirAll(path string, perm os.FileMode) error {
+	return readOnly("mkdir", path)
+}
+
+func (fs *indexFs) Open(name string) (afero.File, error) {
+	entry, ok := fs.lookup(name)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	f := &indexFile{fs: fs, name: name, path: indexPath(name), entry: entry}
+	if entry.info.IsDir() {
+		f.content = bytes.NewReader(nil)
+		return f, nil
+	}
+	if !entry.info.Mode().IsRegular() || entry.open == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EINVAL}
+	}
+	stream, err := entry.open()
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f.stream = stream
+	return f, nil
+}
+
+func (fs *indexFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, readOnly("open", name)
+	}
+	return fs.Open(name)
+}
+
+func (fs *indexFs) Remove(name string) error {
+	return readOnly("remove", name)
+}
+
+func (fs *indexFs) RemoveAll(path string) error {
+	return readOnly("remove", path)
+}
+
+func (fs *indexFs) Rename(oldname, newname string) error {
+	return readOnly("rename", oldname)
+}
+
+func (fs *indexFs) Stat(name string) (os.FileInfo, error) {
+	entry, ok := fs.lookup(name)
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return entry.info, nil
+}
+
+func (fs *indexFs) Name() string {
+	return fs.name
+}
+
+func (fs *indexFs) Chmod(name string, mode os.FileMode) error {
+	return readOnly("chmod", name)
+}
+
+func (fs *indexFs) Chtimes(name string, atime, mtime time.Time) error {
+	return readOnly("chtimes", name)
+}
+
+// indexFile is a file or directory opened from an indexFs. A file's
+// content is streamed as it is read, and only held in memory once it is
+// read at an offset or seeked in, as for zip archives.
+type indexFile struct {
+	fs    *indexFs
+	name  string
+	path  string
+	entry *indexEntry
+	// stream is the file's content, read offset bytes into.
+	stream io.ReadCloser
+	offset int64
+	// content is the file's content once held in memory.
+	content *bytes.Reader
+	listed  bool
+}
+
+func (f *indexFile) Read(p []byte) (int, error) {
+	if f.content != nil {
+		return f.content.Read(p)
+	}
+	n, err := f.stream.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// load reads the whole file into memory, keeping the offset read to.
+func (f *indexFile) load() error {
+	if f.content != nil {
+		return nil
+	}
+	// Streams from one layer can't be open side by side, so the file is
+	// read afresh from the start.
+	f.stream.Close()
+	stream, err := f.entry.open()
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(stream)
+	stream.Close()
+	if err != nil {
+		return err
+	}
+	f.content = bytes.NewReader(content)
+	_, err = f.content.Seek(f.offset, io.SeekStart)
+	return err
+}
+
+func (f *indexFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	return f.content.ReadAt(p, off)
+}
+
+func (f *indexFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	return f.content.Seek(offset, whence)
+}
+
+func (f *indexFile) Close() error {
+	if f.content == nil {
+		return f.stream.Close()
+	}
+	return nil
+}
+
+func (f *indexFile) Name() string {
+	return f.name
+}
+
+func (f *indexFile) Readdir(count int) ([]os.FileInfo, error) {
+	names, err := f.Readdirnames(count)
+	var infos []os.FileInfo
+	for _, name := range names {
+		entry, _ := f.fs.lookup(path.Join(f.path, name))
+		infos = append(infos, entry.info)
+	}
+	return infos, err
+}
+
+// Readdirnames returns all of a directory's entries on the first call,
+// and nothing after that.
+func (f *indexFile) Readdirnames(n int) ([]string, error) {
+	if !f.entry.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	if f.listed {
+		if n > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	f.listed = true
+	return f.fs.list(f.path), nil
+}
+
+func (f *indexFile) Stat() (os.FileInfo, error) {
+	return f.entry.info, nil
+}
+
+func (f *indexFile) Sync() error {
+	return nil
+}
+
+func (f *indexFile) Truncate(size int64) error {
+	return readOnly("truncate", f.name)
+}
+
+func (f *indexFile) Write(p []byte) (int, error) {
+	return 0, readOnly("write", f.name)
+}
+
+func (f *indexFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, readOnly("write", f.name)
+}
+
+func (f *indexFile) WriteString(s string) (int, error) {
+	return 0, readOnly("write", f.name)
+}
+
+// indexDirInfo describes a directory that has no file info of its own,
+// such as the parents of files in an image layer.
+type indexDirInfo struct {
+	name string
+}
+
+func (d indexDirInfo) Name() string       { return d.name }
+func (d indexDirInfo) Size() int64        { return 0 }
+func (d indexDirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (d indexDirInfo) ModTime() time.Time { return time.Time{} }
+func (d indexDirInfo) IsDir() bool        { return true }
+func (d indexDirInfo) Sys() interface{}   { return nil }
+
+// renamedInfo is file info reported under another name, as for hard
+// links.
+type renamedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (r renamedInfo) Name() string { return r.name }
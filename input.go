@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zipMagic   = []byte("PK\x03\x04")
+)
+
+// stdinPath is the path that makes openInput read standard input.
+const stdinPath = "-"
+
+// inputFile is a file opened by openInput. Reading it yields the
+// decompressed contents.
+type inputFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes the decompressor and the underlying file.
+func (f *inputFile) Close() error {
+	var err error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		if closeErr := f.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// openInput opens a file for reading, or standard input if path is
+// "-". Files compressed with gzip or bzip2 are transparently
+// decompressed, judging by their magic bytes rather than their name.
+// Reading a zip archive yields the contents of all of its members, one
+// after the other.
+func openInput(path string, fs afero.Fs) (io.ReadCloser, error) {
+	var f io.ReadCloser
+	if path == stdinPath {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = fs.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	input := &inputFile{closers: []io.Closer{f}}
+	buffered := bufio.NewReader(f)
+	magic, _ := buffered.Peek(len(zipMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		input.Reader = gz
+		input.closers = append(input.closers, gz)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		input.Reader = bzip2.NewReader(buffered)
+	case bytes.HasPrefix(magic, zipMagic):
+		file, ok := f.(afero.File)
+		if !ok || path == stdinPath {
+			return nil, errors.New("zip archives can't be read from standard input")
+		}
+		members, err := zipMembers(file)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		input.Reader = members
+		input.closers = append(input.closers, members)
+	default:
+		input.Reader = buffered
+	}
+	return input, nil
+}
+
+// isCompressed reports whether a file starts with the magic bytes of a
+// format openInput decompresses.
+func isCompressed(path string, fs afero.Fs) bool {
+	f, err := fs.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(zipMagic))
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	return bytes.HasPrefix(magic, gzipMagic) ||
+		bytes.HasPrefix(magic, bzip2Magic) ||
+		bytes.HasPrefix(magic, zipMagic)
+}
+
+// zipMembers returns a reader of the regular files in a zip archive,
+// one after the other. Zip archives keep their directory at the end, so
+// unlike the other formats they can't be read from a stream such as
+// standard input.
+func zipMembers(file afero.File) (*zipReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	z := &zipReader{}
+	for _, member := range archive.File {
+		if member.Mode().IsRegular() {
+			z.files = append(z.files, member)
+		}
+	}
+	return z, nil
+}
+
+// zipReader reads the members of a zip archive in turn, opening each
+// only once the one before it has been read, so that no more than one
+// is open at a time.
+type zipReader struct {
+	files  []*zip.File
+	member io.ReadCloser
+}
+
+func (z *zipReader) Read(p []byte) (int, error) {
+	for {
+		if z.member == nil {
+			if len(z.files) == 0 {
+				return 0, io.EOF
+			}
+			member, err := z.files[0].Open()
+			if err != nil {
+				return 0, err
+			}
+			z.member, z.files = member, z.files[1:]
+		}
+		n, err := z.member.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		z.member.Close()
+		z.member = nil
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close closes the member being read, if any.
+func (z *zipReader) Close() error {
+	if z.member == nil {
+		return nil
+	}
+	err := z.member.Close()
+	z.member = nil
+	return err
+}
+
+// readPaths calls fn with each path listed in r, one per line, or
+// terminated by NUL bytes when null is set, as written by find -print0.
+// Empty entries are skipped, as are carriage returns ending lines.
+// Paths are passed on as they are read, so lists of any length can be
+// streamed.
+func readPaths(r io.Reader, null bool, fn func(path string)) error {
+	scanner := bufio.NewScanner(r)
+	if null {
+		scanner.Split(scanNull)
+	}
+	for scanner.Scan() {
+		path := scanner.Text()
+		if !null {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path != "" {
+			fn(path)
+		}
+	}
+	return scanner.Err()
+}
+
+// scanNull is a bufio.SplitFunc that splits input at NUL bytes.
+func scanNull(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i != -1 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// The bzip2 compressed form of "0cc175b9c0f1b6a831c399e269772661\n".
+const bzip2Hashes = "425a6839314159265359f12caf1b000000c90000107be03b002000229a7a9b49934f50a600034d70ca2575c1ac215e468ec3a12106f8bb9229c28487896578d8"
+
+func TestOpenInput(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+	expected := "0cc175b9c0f1b6a831c399e269772661\n"
+
+	afero.WriteFile(fs, fakeDir+"plain.txt", []byte(expected), 0644)
+
+	var gzBuf bytes.Buffer
+	gz := gzip.NewWriter(&gzBuf)
+	gz.Write([]byte(expected))
+	gz.Close()
+	afero.WriteFile(fs, fakeDir+"hashes.gz", gzBuf.Bytes(), 0644)
+
+	bz2, _ := hex.DecodeString(bzip2Hashes)
+	afero.WriteFile(fs, fakeDir+"hashes.bz2", bz2, 0644)
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, _ := zw.Create("part1.txt")
+	w.Write([]byte(expected[:10]))
+	zw.Create("empty/")
+	zw.Create("blank.txt")
+	w, _ = zw.Create("part2.txt")
+	w.Write([]byte(expected[10:]))
+	zw.Close()
+	afero.WriteFile(fs, fakeDir+"hashes.zip", zipBuf.Bytes(), 0644)
+
+	for _, name := range []string{"plain.txt", "hashes.gz", "hashes.bz2", "hashes.zip"} {
+		f, err := openInput(fakeDir+name, fs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Errorf(
+				"openInput: %s: expected: %q actual: %q",
+				name,
+				expected,
+				content,
+			)
+		}
+		compressed := name != "plain.txt"
+		if isCompressed(fakeDir+name, fs) != compressed {
+			t.Errorf(
+				"isCompressed: %s: expected: %t actual: %t",
+				name,
+				compressed,
+				!compressed,
+			)
+		}
+	}
+}
+
+func TestReadPaths(t *testing.T) {
+	tests := []struct {
+		input    string
+		null     bool
+		expected []string
+	}{
+		{"/etc/passwd\n/tmp/a b\r\n\n/var/log", false, []string{"/etc/passwd", "/tmp/a b", "/var/log"}},
+		{"./new\nline\x00./b\x00\x00", true, []string{"./new\nline", "./b"}},
+	}
+	for _, test := range tests {
+		var paths []string
+		err := readPaths(strings.NewReader(test.input), test.null, func(path string) {
+			paths = append(paths, path)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(paths, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("Input: readPaths: expected: %q actual: %q", test.expected, paths)
+		}
+	}
+}
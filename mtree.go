@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// mtreeEntry is one file described by an mtree specification.
+type mtreeEntry struct {
+	Path     string
+	Keywords map[string]string
+}
+
+// Type returns the entry's file type, which mtree defaults to "file".
+func (e mtreeEntry) Type() string {
+	if t, ok := e.Keywords["type"]; ok {
+		return t
+	}
+	return "file"
+}
+
+// Digest returns the entry's digest for a hash algorithm such as
+// "md5", accepting both the md5 and md5digest spellings of the keyword.
+func (e mtreeEntry) Digest(alg string) string {
+	if digest, ok := e.Keywords[alg+"digest"]; ok {
+		return strings.ToLower(digest)
+	}
+	return strings.ToLower(e.Keywords[alg])
+}
+
+// parseMtree reads an mtree specification as described in mtree(5).
+// Both the hierarchical format, where directory entries change the
+// current directory until a matching "..", and the full path format
+// written by bsdtar are supported.
+func parseMtree(r io.Reader) ([]mtreeEntry, error) {
+	var entries []mtreeEntry
+	defaults := make(map[string]string)
+	// dirs holds the directories entered so far; the last one is the
+	// current directory.
+	var dirs []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+	var line string
+	for scanner.Scan() {
+		lineNum++
+		text := scanner.Text()
+		if strings.HasSuffix(text, "\\") && !strings.HasSuffix(text, "\\\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		line += text
+		fields := strings.Fields(line)
+		line = ""
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "/set":
+			for k, v := range mtreeKeywords(fields[1:]) {
+				defaults[k] = v
+			}
+			continue
+		case "/unset":
+			for _, k := range fields[1:] {
+				if k == "all" {
+					defaults = make(map[string]string)
+				}
+				delete(defaults, k)
+			}
+			continue
+		case "..":
+			if len(dirs) == 0 {
+				return nil, fmt.Errorf("line %d: \"..\" above the root directory", lineNum)
+			}
+			dirs = dirs[:len(dirs)-1]
+			continue
+		}
+		name, err := mtreeUnescape(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		keywords := make(map[string]string)
+		for k, v := range defaults {
+			keywords[k] = v
+		}
+		for k, v := range mtreeKeywords(fields[1:]) {
+			keywords[k] = v
+		}
+		entry := mtreeEntry{Keywords: keywords}
+		if strings.Contains(name, "/") {
+			entry.Path = path.Clean(name)
+		} else {
+			cwd := "."
+			if len(dirs) > 0 {
+				cwd = dirs[len(dirs)-1]
+			}
+			entry.Path = path.Join(cwd, name)
+			if entry.Type() == "dir" {
+				dirs = append(dirs, entry.Path)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func mtreeKeywords(fields []string) map[string]string {
+	keywords := make(map[string]string)
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) == 2 {
+			keywords[kv[0]] = kv[1]
+		} else {
+			keywords[kv[0]] = ""
+		}
+	}
+	return keywords
+}
+
+// mtreeUnescape decodes the vis(3) style escapes used in mtree names.
+func mtreeUnescape(name string) (string, error) {
+	if !strings.Contains(name, "\\") {
+		return name, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '\\' || i+1 == len(name) {
+			b.WriteByte(name[i])
+			continue
+		}
+		i++
+		switch c := name[i]; {
+		case c >= '0' && c <= '7':
+			if i+3 > len(name) {
+				return "", fmt.Errorf("short octal escape in %q", name)
+			}
+			value, err := strconv.ParseUint(name[i:i+3], 8, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid octal escape in %q", name)
+			}
+			b.WriteByte(byte(value))
+			i += 2
+		case c == 's':
+			b.WriteByte(' ')
+		case c == 't':
+			b.WriteByte('\t')
+		case c == 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// mtreeEscape encodes a name so that it survives as a single field in
+// an mtree specification.
+func mtreeEscape(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\\#*?[", c) != -1 {
+			fmt.Fprintf(&b, "\\%03o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// MtreeSpec collects the files visited by CalculateHashes so they can
+// be written out as an mtree specification in the full path format.
+type MtreeSpec struct {
+	entries map[string]mtreeEntry
+}
+
+// Add records a file or directory at rel, a path relative to the root
+// of the scan, along with the file's digests by hash algorithm.
+func (s *MtreeSpec) Add(rel string, info os.FileInfo, digests map[string]string) {
+	if s.entries == nil {
+		s.entries = make(map[string]mtreeEntry)
+	}
+	rel = path.Join(".", filepath.ToSlash(rel))
+	keywords := map[string]string{
+		"mode": fmt.Sprintf("%04o", info.Mode().Perm()),
+		"time": fmt.Sprintf("%d.%09d", info.ModTime().Unix(), info.ModTime().Nanosecond()),
+	}
+	if info.IsDir() {
+		keywords["type"] = "dir"
+	} else {
+		keywords["type"] = "file"
+		keywords["size"] = strconv.FormatInt(info.Size(), 10)
+		for alg, digest := range digests {
+			keywords[alg+"digest"] = digest
+		}
+	}
+	s.entries[rel] = mtreeEntry{Path: rel, Keywords: keywords}
+}
+
+// Write writes the specification, sorted by path.
+func (s *MtreeSpec) Write(w io.Writer) error {
+	var paths []string
+	for p := range s.entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if _, err := fmt.Fprint(w, "#mtree\n"); err != nil {
+		return err
+	}
+	order := []string{"type", "mode", "size", "time"}
+	for _, alg := range digestAlgs {
+		order = append(order, alg+"digest")
+	}
+	for _, p := range paths {
+		entry := s.entries[p]
+		// A leading "./" keeps every name in the full path format.
+		name := "."
+		if p != "." {
+			name = "./" + mtreeEscape(p)
+		}
+		fields := []string{name}
+		for _, k := range order {
+			if v, ok := entry.Keywords[k]; ok {
+				fields = append(fields, k+"="+v)
+			}
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mtreeDigests returns the MD5 digests of the regular files described
+// by an mtree spec, which may be gzip compressed.
+func mtreeDigests(specFile string, fs afero.Fs) []string {
+	f, err := openInput(specFile, fs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := parseMtree(f)
+	if err != nil {
+		log.Fatalf("Invalid mtree spec %s: %s", specFile, err)
+	}
+	var digests []string
+	for _, entry := range entries {
+		digest := entry.Digest("md5")
+		if entry.Type() == "file" && isMD5(digest) {
+			digests = append(digests, digest)
+		}
+	}
+	return digests
+}
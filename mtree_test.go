@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+const hierarchicalSpec = `#mtree
+/set type=file mode=0644
+.               type=dir
+    index.php   size=5 md5digest=D9E6762DD1C8EAF6D61B3C6192FC408D
+    wp-admin    type=dir mode=0755
+        admin.php \
+                size=5 md5=45d92ef8d6cec1aa5ac0e71a0c40d8d3
+    ..
+    with\040space.php sha256digest=ab
+/unset all
+    wp-includes type=dir
+        link type=link
+    ..
+..
+`
+
+func TestParseMtree(t *testing.T) {
+	entries, err := parseMtree(strings.NewReader(hierarchicalSpec))
+	if err != nil {
+		log.Fatal(err)
+	}
+	expected := []string{
+		".",
+		"index.php",
+		"wp-admin",
+		"wp-admin/admin.php",
+		"with space.php",
+		"wp-includes",
+		"wp-includes/link",
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf(
+			"parseMtree: entries: expected: %d actual: %d",
+			len(expected),
+			len(entries),
+		)
+	}
+	for i, entry := range entries {
+		if entry.Path != expected[i] {
+			t.Errorf(
+				"parseMtree: Path: expected: %s actual: %s",
+				expected[i],
+				entry.Path,
+			)
+		}
+	}
+	digest := entries[1].Digest("md5")
+	if digest != "d9e6762dd1c8eaf6d61b3c6192fc408d" {
+		t.Errorf("parseMtree: md5digest: expected lower case digest actual: %s", digest)
+	}
+	digest = entries[3].Digest("md5")
+	if digest != "45d92ef8d6cec1aa5ac0e71a0c40d8d3" {
+		t.Errorf("parseMtree: md5 continued line: actual: %s", digest)
+	}
+	if entries[3].Keywords["mode"] != "0644" {
+		t.Errorf(
+			"parseMtree: /set mode: expected: 0644 actual: %s",
+			entries[3].Keywords["mode"],
+		)
+	}
+	if entries[6].Type() != "link" {
+		t.Errorf("parseMtree: Type: expected: link actual: %s", entries[6].Type())
+	}
+
+	_, err = parseMtree(strings.NewReader(".. type=dir\n"))
+	if err == nil {
+		t.Errorf("parseMtree: expected error for \"..\" above the root")
+	}
+}
+
+func TestMtreeSpecRoundTrip(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+	fs.MkdirAll(fakeDir+"sub dir", 0755)
+	afero.WriteFile(fs, fakeDir+"file1.txt", []byte("file1"), 0644)
+	afero.WriteFile(fs, fakeDir+"sub dir/file#2.txt", []byte("file2"), 0644)
+
+	bloomFilter := NewBloomFilter(2, 0.01, fs)
+	bloomFilter.Spec = &MtreeSpec{}
+	bloomFilter.CalculateHashes(fakeDir)
+
+	var buf bytes.Buffer
+	if err := bloomFilter.Spec.Write(&buf); err != nil {
+		log.Fatal(err)
+	}
+	entries, err := parseMtree(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	digests := make(map[string]string)
+	for _, entry := range entries {
+		digests[entry.Path] = entry.Digest("md5")
+	}
+	for path, fullPath := range map[string]string{
+		"file1.txt":          fakeDir + "file1.txt",
+		"sub dir/file#2.txt": fakeDir + "sub dir/file#2.txt",
+	} {
+		expected := md5File(fullPath, fs)
+		if digests[path] != expected {
+			t.Errorf(
+				"MtreeSpec: %s: expected: %s actual: %s",
+				path,
+				expected,
+				digests[path],
+			)
+		}
+	}
+}
+
+func TestImportMtree(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fs.MkdirAll("/tmp/filters/", 0755)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(hierarchicalSpec))
+	gz.Close()
+	afero.WriteFile(fs, "/tmp/spec.mtree.gz", buf.Bytes(), 0644)
+
+	args := []string{"mdd", "import", "mtree", "/tmp/filters/filterfile", "/tmp/spec.mtree.gz"}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Import()
+
+	bloomFilter := NewBloomFilter(1, 0.01, fs)
+	bloomFilter.Load("/tmp/filters/filterfile")
+	for _, digest := range []string{
+		"d9e6762dd1c8eaf6d61b3c6192fc408d",
+		"45d92ef8d6cec1aa5ac0e71a0c40d8d3",
+	} {
+		if !bloomFilter.Lookup(digest) {
+			t.Errorf("Import: mtree: expected to find: %s", digest)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// textNormalization says how the contents of text files are normalized
+// before hashing, so that a filter built from one platform's checkout
+// matches another's. It is stored in the filter header.
+type textNormalization byte
+
+const (
+	// normalizeEOL turns CRLF line endings into LF.
+	normalizeEOL textNormalization = 1 << iota
+	// normalizeBOM strips a leading UTF-8 byte order mark.
+	normalizeBOM
+)
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// textTypes are the detected types normalization applies to.
+var textTypes = []string{
+	"text",
+	"html",
+	"svg",
+	"php",
+	"javascript",
+	"shell",
+	"python",
+	"perl",
+	"ruby",
+	"lua",
+	"script",
+}
+
+// String describes the normalization for output.
+func (n textNormalization) String() string {
+	var parts []string
+	if n&normalizeEOL != 0 {
+		parts = append(parts, "CRLF to LF")
+	}
+	if n&normalizeBOM != 0 {
+		parts = append(parts, "BOM stripped")
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// eolWriter turns CRLF into LF in what is written through it. A CR at
+// the end of one write is held back until the next shows whether an LF
+// follows.
+type eolWriter struct {
+	w  io.Writer
+	cr bool
+}
+
+func (e *eolWriter) Write(p []byte) (int, error) {
+	var out []byte
+	for i, b := range p {
+		if e.cr && b != '\n' {
+			out = append(out, '\r')
+		}
+		e.cr = false
+		if b == '\r' {
+			e.cr = true
+			continue
+		}
+		if out == nil {
+			// Copy only once something has to change.
+			if bytes.IndexByte(p[i:], '\r') == -1 {
+				if _, err := e.w.Write(p[i:]); err != nil {
+					return 0, err
+				}
+				return len(p), nil
+			}
+			out = make([]byte, 0, len(p)-i)
+		}
+		out = append(out, b)
+	}
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes a CR held back at the end of the input.
+func (e *eolWriter) Close() error {
+	if e.cr {
+		e.cr = false
+		_, err := e.w.Write([]byte{'\r'})
+		return err
+	}
+	return nil
+}
+
+// digestKind says what a digest is of: the hash algorithm, and any
+// normalization of text files applied first.
+type digestKind struct {
+	Alg       string
+	Normalize textNormalization
+}
+
+// String names the kind of digest, as in md5 or md5/1 for MD5 digests
+// of text with its line endings normalized.
+func (k digestKind) String() string {
+	if k.Normalize == 0 {
+		return k.Alg
+	}
+	return fmt.Sprintf("%s/%d", k.Alg, k.Normalize)
+}
+
+// skipWriter drops the first n bytes written through it.
+type skipWriter struct {
+	w io.Writer
+	n int
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	if s.n > 0 {
+		skip := s.n
+		if skip > len(p) {
+			skip = len(p)
+		}
+		s.n -= skip
+		p = p[skip:]
+	}
+	if len(p) > 0 {
+		if _, err := s.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return written, nil
+}
+
+// hashNormalized hashes r like hashReader, first normalizing its
+// contents if they are text of one of textTypes. kind is the detected
+// type of the contents, or "" to detect it here.
+func hashNormalized(r io.Reader, alg, kind string, mode textNormalization) (string, error) {
+	want := digestKind{alg, mode}
+	digests, err := hashDigests(r, []digestKind{want}, kind)
+	if err != nil {
+		return "", err
+	}
+	return digests[want], nil
+}
+
+// hashDigests calculates digests of each of kinds from a single read
+// of r. Text of one of textTypes is normalized as each kind says, while
+// other content is hashed as it is. kind is the detected type of the
+// contents, or "" to detect it here if needed.
+func hashDigests(r io.Reader, kinds []digestKind, kind string) (map[digestKind]string, error) {
+	buffered := bufio.NewReaderSize(r, typeHeaderSize)
+	normalize := false
+	for _, k := range kinds {
+		normalize = normalize || k.Normalize != 0
+	}
+	bom := false
+	if normalize {
+		if kind == "" {
+			header, _ := buffered.Peek(typeHeaderSize)
+			kind = detectType(header)
+		}
+		normalize = contains(textTypes, kind)
+		start, _ := buffered.Peek(len(utf8BOM))
+		bom = bytes.Equal(start, utf8BOM)
+	}
+
+	// Hashers of digests normalized the same way share a writer.
+	hashers := make(map[digestKind]hash.Hash)
+	groups := make(map[textNormalization][]io.Writer)
+	var modes []textNormalization
+	for _, k := range kinds {
+		if _, ok := hashers[k]; ok {
+			continue
+		}
+		h := getHasher(k.Alg)
+		hashers[k] = h
+		mode := k.Normalize
+		if !normalize {
+			mode = 0
+		}
+		if _, ok := groups[mode]; !ok {
+			modes = append(modes, mode)
+		}
+		groups[mode] = append(groups[mode], h)
+	}
+	var writers []io.Writer
+	var eols []*eolWriter
+	for _, mode := range modes {
+		w := io.MultiWriter(groups[mode]...)
+		if mode&normalizeEOL != 0 {
+			eol := &eolWriter{w: w}
+			eols = append(eols, eol)
+			w = eol
+		}
+		if mode&normalizeBOM != 0 && bom {
+			w = &skipWriter{w: w, n: len(utf8BOM)}
+		}
+		writers = append(writers, w)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), buffered); err != nil {
+		return nil, err
+	}
+	for _, eol := range eols {
+		if err := eol.Close(); err != nil {
+			return nil, err
+		}
+	}
+	digests := make(map[digestKind]string)
+	for k, h := range hashers {
+		digests[k] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return digests, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/spf13/afero"
+)
+
+func TestEOLWriter(t *testing.T) {
+	tests := map[string]string{
+		"a\r\nb\r\n":   "a\nb\n",
+		"a\rb\r":       "a\rb\r",
+		"a\r\r\nb":     "a\r\nb",
+		"no endings":   "no endings",
+		"\r\n\r\n\r\n": "\n\n\n",
+	}
+	for input, expected := range tests {
+		var buf bytes.Buffer
+		eol := &eolWriter{w: &buf}
+		// One byte at a time, so CRs land at the end of writes.
+		for _, b := range []byte(input) {
+			eol.Write([]byte{b})
+		}
+		eol.Close()
+		if buf.String() != expected {
+			t.Errorf("eolWriter: %q: expected: %q actual: %q", input, expected, buf.String())
+		}
+	}
+}
+
+func TestHashNormalized(t *testing.T) {
+	unix, _ := hashReader(strings.NewReader("<?php\necho 1;\n"), "md5")
+	tests := []struct {
+		content string
+		mode    textNormalization
+		match   bool
+	}{
+		{"<?php\r\necho 1;\r\n", 0, false},
+		{"<?php\r\necho 1;\r\n", normalizeEOL, true},
+		{"\xef\xbb\xbf<?php\r\necho 1;\r\n", normalizeEOL, false},
+		{"\xef\xbb\xbf<?php\r\necho 1;\r\n", normalizeEOL | normalizeBOM, true},
+		{"\xef\xbb\xbf<?php\necho 1;\n", normalizeBOM, true},
+	}
+	for _, test := range tests {
+		reader := iotest.OneByteReader(strings.NewReader(test.content))
+		digest, err := hashNormalized(reader, "md5", "", test.mode)
+		if err != nil {
+			t.Errorf("hashNormalized: %q: unexpected error: %v", test.content, err)
+		}
+		if (digest == unix) != test.match {
+			t.Errorf("hashNormalized: %q with %s: expected match: %v", test.content, test.mode, test.match)
+		}
+	}
+
+	binary := "\x7fELF\r\n\x00\x01"
+	raw, _ := hashReader(strings.NewReader(binary), "md5")
+	digest, _ := hashNormalized(strings.NewReader(binary), "md5", "", normalizeEOL|normalizeBOM)
+	if digest != raw {
+		t.Errorf("hashNormalized: expected binary files to be hashed as they are")
+	}
+}
+
+func TestCalculateNormalized(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/www/"
+	fs.MkdirAll(fakeDir, 0755)
+	afero.WriteFile(fs, fakeDir+"index.php", []byte("<?php\r\necho 1;\r\n"), 0644)
+
+	fakeFilterDir := "/tmp/filters/"
+	fs.MkdirAll(fakeFilterDir, 0755)
+	args := []string{"mdd", "calculate", "--normalize", "--strip-bom", fakeFilterDir + "filterfile", fakeDir}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Calculate()
+
+	bloomFilter := NewBloomFilter(1, 0.01, fs)
+	bloomFilter.Load(fakeFilterDir + "filterfile")
+	if bloomFilter.Normalize != normalizeEOL|normalizeBOM {
+		t.Errorf("Load: expected normalization in header: %s", bloomFilter.Normalize)
+	}
+	digest, _ := hashNormalized(strings.NewReader("<?php\necho 1;\n"), "md5", "", bloomFilter.Normalize)
+	if !bloomFilter.Lookup(digest) {
+		t.Errorf("Calculate: --normalize: expected LF checkout to match CRLF build")
+	}
+}
+
+func TestHashDigests(t *testing.T) {
+	content := "\xef\xbb\xbf<?php\r\necho 1;\r\n"
+	kinds := []digestKind{
+		{"md5", 0},
+		{"sha256", 0},
+		{"md5", normalizeEOL},
+		{"sha1", normalizeEOL | normalizeBOM},
+		{"sha512", normalizeBOM},
+	}
+	// A reader returning a byte at a time splits the BOM and CRLFs.
+	digests, err := hashDigests(iotest.OneByteReader(strings.NewReader(content)), kinds, "")
+	if err != nil {
+		t.Fatalf("hashDigests: unexpected error: %v", err)
+	}
+	for _, kind := range kinds {
+		expected, _ := hashNormalized(strings.NewReader(content), kind.Alg, "", kind.Normalize)
+		if digests[kind] != expected {
+			t.Errorf("hashDigests: %s: expected: %s actual: %s", kind, expected, digests[kind])
+		}
+	}
+	unix, _ := hashReader(strings.NewReader("<?php\necho 1;\n"), "sha1")
+	if digests[kinds[3]] != unix {
+		t.Errorf("hashDigests: sha1/3: expected: %s actual: %s", unix, digests[kinds[3]])
+	}
+}
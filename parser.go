@@ -1,20 +1,23 @@
 package main
 
 import (
-	"bufio"
-	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/afero"
 )
 
 func usage(progName string) {
-	fmt.Printf("usage: %s <calculate|lookup|fromfile|filters> <filterfile> <file1> [file2 ...]\n", progName)
+	fmt.Printf("usage: %s <calculate|lookup|check|identify|fromfile|import|filters> <filterfile> <file1> [file2 ...]\n", progName)
 	os.Exit(1)
 }
 
@@ -29,7 +32,32 @@ func isMD5(value string) bool {
 	return true
 }
 
+// digestType names the hash algorithm a hex digest belongs to, judging
+// by its length, or returns "" if value isn't a hex digest.
+func digestType(value string) string {
+	if _, err := hex.DecodeString(value); err != nil {
+		return ""
+	}
+	switch len(value) {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	case 128:
+		return "sha512"
+	}
+	return ""
+}
+
 func md5File(path string, fs afero.Fs) string {
+	return hashFile(path, "md5", fs)
+}
+
+// hashFile returns the hex digest of a file using the named hash
+// algorithm, or "" if the file can't be read for lack of permission.
+func hashFile(path string, alg string, fs afero.Fs) string {
 	f, err := fs.Open(path)
 	if os.IsPermission(err) {
 		return ""
@@ -39,30 +67,25 @@ func md5File(path string, fs afero.Fs) string {
 	}
 	defer f.Close()
 
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
+	digest, err := hashReader(f, alg)
+	if err != nil {
 		log.Fatal(err)
 	}
+	return digest
+}
 
-	return fmt.Sprintf("%x", h.Sum(nil))
+// hashReader returns the hex digest of everything read from r using the
+// named hash algorithm.
+func hashReader(r io.Reader, alg string) (string, error) {
+	h := getHasher(alg)
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
 func countFiles(path string, fs afero.Fs) int32 {
-	var count int32
-	err := afero.Walk(fs, path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Printf("Error accessing path %q while counting files: %v\n", path, err)
-			return err
-		}
-		if info.Mode().IsRegular() {
-			count++
-		}
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-	return count
+	return NewScanner(fs).Count(path)
 }
 
 func readableFile(path string, fs afero.Fs) bool {
@@ -83,6 +106,266 @@ func writeableFile(path string, fs afero.Fs) bool {
 	return true
 }
 
+// options holds the --name and --name=value options given to a
+// command, keyed by name. Options may be repeated.
+type options map[string][]string
+
+// parseOptions separates options from positional arguments. Options
+// listed in valued take the following argument as their value when it
+// isn't given with "=". A lone "--" ends option parsing.
+func parseOptions(args []string, valued ...string) (options, []string) {
+	opts := make(options)
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			positional = append(positional, arg)
+			continue
+		}
+		name := strings.TrimPrefix(arg, "--")
+		value := ""
+		if eq := strings.Index(name, "="); eq != -1 {
+			name, value = name[:eq], name[eq+1:]
+		} else {
+			for _, v := range valued {
+				if v == name && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+			}
+		}
+		opts[name] = append(opts[name], value)
+	}
+	return opts, positional
+}
+
+// Has reports whether the named option was given.
+func (o options) Has(name string) bool {
+	_, ok := o[name]
+	return ok
+}
+
+// Get returns the last value given for the named option.
+func (o options) Get(name string) string {
+	values := o[name]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// scanValued lists the scan options that take a value, for commands
+// that walk files.
+var scanValued = []string{
+	"archive-depth",
+	"archive-size",
+	"strip-components",
+	"image",
+	"git",
+	"cache",
+	"exclude",
+	"include",
+	"ext",
+	"min-size",
+	"max-size",
+	"newer",
+	"older",
+	"type",
+}
+
+// newScanner constructs a Scanner configured by the scan options:
+//
+//	--archives            look inside archives
+//	--archive-depth <n>   open archives nested up to n deep
+//	--archive-size <n>    decompress at most n bytes from an archive
+//	--from-archive        scan the members of the archives named
+//	--strip-components <n>
+//	                      drop n leading directories from member names
+//	--image <path>        scan the files of an OCI image layout or a
+//	                      docker save tarball
+//	--git <repo>[@<ref>]  scan the tree of a git revision
+//	--type <type,...>     only scan files whose contents are of these
+//	                      types, such as php, elf or executable
+//	--follow-symlinks     follow symlinks to files and directories
+//	--report-symlinks     report symlinks pointing outside the scan root
+//	--one-file-system     don't cross into other filesystems
+//	--report-special      report named pipes, sockets and devices
+//
+// and the filter options read by scanFilterOptions.
+func (p Parser) newScanner(opts options) *Scanner {
+	scanner := NewScanner(p.Fs)
+	scanner.Filter = p.scanFilterOptions(opts)
+	for _, list := range opts["type"] {
+		for _, kind := range strings.Split(list, ",") {
+			if kind = normalizeType(kind); kind != "" {
+				scanner.Types = append(scanner.Types, kind)
+			}
+		}
+	}
+	if opts.Has("image") {
+		image, err := NewImageFs(opts.Get("image"), p.Fs)
+		if err != nil {
+			fmt.Printf("[-] Unable to read image %s: %v\n", opts.Get("image"), err)
+			os.Exit(1)
+		}
+		scanner.Fs = image
+	}
+	if opts.Has("git") {
+		repo, rev := splitGitSource(opts.Get("git"), p.Fs)
+		tree, err := NewGitFs(repo, rev, p.Fs)
+		if err != nil {
+			fmt.Printf("[-] Unable to read git revision %s: %v\n", opts.Get("git"), err)
+			os.Exit(1)
+		}
+		scanner.Fs = tree
+	}
+	scanner.FollowSymlinks = opts.Has("follow-symlinks")
+	scanner.ReportSymlinks = opts.Has("report-symlinks")
+	scanner.OneFileSystem = opts.Has("one-file-system")
+	scanner.ReportSpecial = opts.Has("report-special")
+	scanner.Archives = opts.Has("archives")
+	scanner.FromArchive = opts.Has("from-archive")
+	if opts.Has("strip-components") {
+		strip, err := strconv.Atoi(opts.Get("strip-components"))
+		if err != nil || strip < 0 {
+			fmt.Printf("[-] Invalid strip components: %s\n", opts.Get("strip-components"))
+			usage(p.Args[0])
+		}
+		scanner.StripComponents = strip
+	}
+	if opts.Has("archive-depth") {
+		depth, err := strconv.Atoi(opts.Get("archive-depth"))
+		if err != nil || depth < 1 {
+			fmt.Printf("[-] Invalid archive depth: %s\n", opts.Get("archive-depth"))
+			usage(p.Args[0])
+		}
+		scanner.MaxDepth = depth
+	}
+	if opts.Has("archive-size") {
+		size, err := strconv.ParseInt(opts.Get("archive-size"), 10, 64)
+		if err != nil || size < 1 {
+			fmt.Printf("[-] Invalid archive size: %s\n", opts.Get("archive-size"))
+			usage(p.Args[0])
+		}
+		scanner.MaxSize = size
+	}
+	return scanner
+}
+
+// hashCache returns the hash cache named by --cache, or the default
+// one, unless --no-cache is given.
+func (p Parser) hashCache(opts options) *HashCache {
+	if opts.Has("no-cache") {
+		return nil
+	}
+	path := opts.Get("cache")
+	if path == "" {
+		path = defaultHashCachePath()
+	}
+	return NewHashCache(path, p.Fs)
+}
+
+// saveHashCache writes back a hash cache, if there is one. Failing to
+// save it only costs the next scan time, so it is reported and ignored.
+func saveHashCache(cache *HashCache, out io.Writer) {
+	if cache == nil {
+		return
+	}
+	if err := cache.Save(); err != nil {
+		fmt.Fprintf(out, "[-] Unable to save hash cache %s: %v\n", cache.Path, err)
+	}
+}
+
+// scanRoots returns the paths a command should scan. Images and git
+// trees are scanned from their root unless paths within them are given.
+func scanRoots(files []string, opts options, progName string) []string {
+	if len(files) == 0 && (opts.Has("image") || opts.Has("git")) {
+		return []string{"/"}
+	}
+	if len(files) == 0 {
+		usage(progName)
+	}
+	return files
+}
+
+// scanFilterOptions reads the options that limit which files a scan
+// covers:
+//
+//	--exclude <pattern>   skip files matching a gitignore-style pattern
+//	--include <pattern>   only scan files matching a pattern
+//	--ignore-files        read exclusion rules from .mddignore files
+//	--ext <ext,...>       only scan files with these extensions
+//	--min-size <size>     skip files smaller than size
+//	--max-size <size>     skip files larger than size
+//	--newer <when>        skip files modified before a date or age
+//	--older <when>        skip files modified after a date or age
+func (p Parser) scanFilterOptions(opts options) scanFilter {
+	var filter scanFilter
+	if opts.Has("ignore-files") {
+		filter.IgnoreFile = ignoreFileName
+	}
+	for _, name := range []string{"exclude", "include"} {
+		for _, pattern := range opts[name] {
+			rule, ok, err := parseIgnoreRule(pattern, "")
+			if err != nil {
+				fmt.Printf("[-] --%s: %v\n", name, err)
+				usage(p.Args[0])
+			}
+			if !ok {
+				continue
+			}
+			if name == "exclude" {
+				filter.Excludes = append(filter.Excludes, rule)
+			} else {
+				filter.Includes = append(filter.Includes, rule)
+			}
+		}
+	}
+	for _, list := range opts["ext"] {
+		for _, ext := range strings.Split(list, ",") {
+			if ext = strings.TrimPrefix(strings.TrimSpace(ext), "."); ext != "" {
+				filter.Extensions = append(filter.Extensions, ext)
+			}
+		}
+	}
+	for _, name := range []string{"min-size", "max-size"} {
+		if !opts.Has(name) {
+			continue
+		}
+		size, err := parseByteSize(opts.Get(name))
+		if err != nil {
+			fmt.Printf("[-] Invalid %s: %v\n", name, err)
+			usage(p.Args[0])
+		}
+		if name == "min-size" {
+			filter.MinSize = size
+		} else {
+			filter.MaxSize = size
+		}
+	}
+	now := time.Now()
+	for _, name := range []string{"newer", "older"} {
+		if !opts.Has(name) {
+			continue
+		}
+		when, err := parseWhen(opts.Get(name), now)
+		if err != nil {
+			fmt.Printf("[-] Invalid %s: %v\n", name, err)
+			usage(p.Args[0])
+		}
+		if name == "newer" {
+			filter.Newer = when
+		} else {
+			filter.Older = when
+		}
+	}
+	return filter
+}
+
 // Parser for command line.
 type Parser struct {
 	Args []string
@@ -92,38 +375,137 @@ type Parser struct {
 // Calculate command parser.
 func (p Parser) Calculate() {
 	progName := p.Args[0]
-	if len(p.Args) < 4 {
+	opts, args := parseOptions(p.Args[2:], append(scanValued, "mtree", "digest", "role")...)
+	if len(args) < 1 {
 		usage(progName)
 	}
-	filterFile := p.Args[2]
-	files := p.Args[3:]
-	if !writeableFile(filterFile, p.Fs) {
-		fmt.Printf("[-] Unable to open %s for writing\n", filterFile)
-		usage(progName)
+	filterFile := args[0]
+	files := scanRoots(args[1:], opts, progName)
+	algs := []string{"md5"}
+	if opts.Has("digest") {
+		algs = nil
+		for _, alg := range strings.Split(opts.Get("digest"), ",") {
+			alg = strings.ToLower(strings.TrimSpace(alg))
+			if !contains(digestAlgs, alg) {
+				fmt.Printf("[-] Invalid digest: %s\n", alg)
+				usage(progName)
+			}
+			if !contains(algs, alg) {
+				algs = append(algs, alg)
+			}
+		}
+	}
+	// Filters of several digests are written next to each other, named
+	// for their digest.
+	filterFiles := []string{filterFile}
+	if len(algs) > 1 {
+		filterFiles = nil
+		for _, alg := range algs {
+			filterFiles = append(filterFiles, filterFile+"."+alg)
+		}
 	}
 
-	fmt.Print("[+] Counting files. This may take a while\n")
-	var size int32
-	for _, file := range files {
-		size += countFiles(file, p.Fs)
+	for _, file := range filterFiles {
+		if !writeableFile(file, p.Fs) {
+			fmt.Printf("[-] Unable to open %s for writing\n", file)
+			usage(progName)
+		}
 	}
-	fmt.Printf("Counted %d files.\n", size)
+	specFile := opts.Get("mtree")
+	if opts.Has("mtree") && !writeableFile(specFile, p.Fs) {
+		fmt.Printf("[-] Unable to open %s for writing\n", specFile)
+		usage(progName)
+	}
+
+	scanner := p.newScanner(opts)
+
+	var newFilter func() BloomFilter
+	if scanner.FromArchive {
+		// Counting members would mean decompressing every archive
+		// twice, so start from a guess and let the filter grow.
+		for _, file := range files {
+			if !readableFile(file, scanner.Fs) {
+				fmt.Printf("[-] Unable to open %s for reading\n", file)
+				usage(progName)
+			}
+		}
+		newFilter = func() BloomFilter {
+			return NewScalableBloomFilter(int32(len(files))*1024, 0.01, p.Fs)
+		}
+	} else {
+		fmt.Print("[+] Counting files. This may take a while\n")
+		var size int32
+		for _, file := range files {
+			size += scanner.Count(file)
+		}
+		fmt.Printf("Counted %d files.\n", size)
+		if opts.Has("paths") {
+			// Each file adds its path, alone and with its digest.
+			size *= 3
+		}
 
-	bloomFilter := NewBloomFilter(size, 0.01, p.Fs)
+		newFilter = func() BloomFilter {
+			if scanner.Archives {
+				// Archive members aren't counted, so let the filter grow.
+				return NewScalableBloomFilter(size, 0.01, p.Fs)
+			}
+			return NewBloomFilter(size, 0.01, p.Fs)
+		}
+	}
+	var normalize textNormalization
+	if opts.Has("normalize") {
+		normalize |= normalizeEOL
+	}
+	if opts.Has("strip-bom") {
+		normalize |= normalizeBOM
+	}
+	if normalize != 0 {
+		fmt.Printf("[+] Normalizing text files: %s\n", normalize)
+	}
+	set := &FilterSet{Scanner: scanner, Cache: p.hashCache(opts)}
+	for _, alg := range algs {
+		bloomFilter := newFilter()
+		bloomFilter.Role = p.filterRole(opts, bloomFilter.Role)
+		bloomFilter.Digest = alg
+		bloomFilter.Normalize = normalize
+		bloomFilter.Paths = opts.Has("paths")
+		if opts.Has("sidecar") {
+			bloomFilter.Sidecar = &Sidecar{}
+		}
+		set.Filters = append(set.Filters, &bloomFilter)
+	}
+	if opts.Has("mtree") {
+		set.Spec = &MtreeSpec{}
+	}
 
 	fmt.Print("[+] Calculating hashes.\n")
 
 	for _, file := range files {
-		bloomFilter.CalculateHashes(file)
+		set.CalculateHashes(file)
 	}
+	saveHashCache(set.Cache, set.Scanner.Out)
 
-	fmt.Printf(
-		"[+] Saving %s filter to outfile: %s\n",
-		bloomFilter.ByteSizeHuman,
-		filterFile,
-	)
-
-	bloomFilter.Save(filterFile)
+	for i, bloomFilter := range set.Filters {
+		fmt.Printf(
+			"[+] Saving %s %s filter to outfile: %s\n",
+			byteSizeHuman(bloomFilter.TotalSize()),
+			bloomFilter.Digest,
+			filterFiles[i],
+		)
+		bloomFilter.Save(filterFiles[i])
+		reportSidecar(bloomFilter, filterFiles[i])
+	}
+	if set.Spec != nil {
+		fmt.Printf("[+] Writing mtree spec to %s\n", specFile)
+		f, err := p.Fs.Create(specFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if err := set.Spec.Write(f); err != nil {
+			log.Fatal(err)
+		}
+	}
 	fmt.Print("[+] Done.\n")
 }
 
@@ -158,84 +540,544 @@ func (p Parser) Filters() {
 	}
 }
 
+// estimateHashes guesses how many hashes a set of hash lists holds from
+// their sizes, so a filter can be sized for them without reading them
+// twice. It assumes 33 byte lines, as for bare MD5 digests, which errs
+// on the generous side for anything longer. Compressed lists are
+// assumed to take half the space. Standard input has no size, so it
+// gets a guess of a million.
+func estimateHashes(files []string, fs afero.Fs) int32 {
+	var estimate int64
+	for _, path := range files {
+		if path == stdinPath {
+			estimate += 1 << 20
+			continue
+		}
+		info, err := fs.Stat(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		size := info.Size()
+		if isCompressed(path, fs) {
+			size *= 2
+		}
+		estimate += size / 33
+	}
+	if estimate < 1024 {
+		estimate = 1024
+	}
+	if estimate > math.MaxInt32 {
+		estimate = math.MaxInt32
+	}
+	return int32(estimate)
+}
+
 // FromFile command parser.
 func (p Parser) FromFile() {
 	progName := p.Args[0]
-	if len(p.Args) < 4 {
+	opts, args := parseOptions(p.Args[2:], "digest", "column", "role")
+	if len(args) < 2 {
 		usage(progName)
 	}
-	filterFile := p.Args[2]
-	files := p.Args[3:]
+	filterFile := args[0]
+	files := args[1:]
 	if !writeableFile(filterFile, p.Fs) {
 		fmt.Printf("[-] Unable to open %s for writing\n", filterFile)
 		usage(progName)
 	}
-
-	fmt.Printf("[+] Counting hashes in %s\n", files)
-	var count int32
-	for _, hashFile := range files {
-		fmt.Printf("%s\n", hashFile)
-		f, err := p.Fs.Open(hashFile)
-		defer f.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if !(strings.HasPrefix(line, "#")) && isMD5(line) {
-				count++
-			}
+	list := hashList{Digest: opts.Get("digest")}
+	if list.Digest != "" && !contains(digestAlgs, list.Digest) {
+		fmt.Printf("[-] Invalid digest: %s\n", list.Digest)
+		usage(progName)
+	}
+	if opts.Has("column") {
+		column, err := strconv.Atoi(opts.Get("column"))
+		if err != nil || column < 1 {
+			fmt.Printf("[-] Invalid column: %s\n", opts.Get("column"))
+			usage(progName)
 		}
+		list.Column = column
 	}
 
-	fmt.Printf("    Counted %d files.\n", count)
+	estimate := estimateHashes(files, p.Fs)
+	fmt.Printf("    Estimated %d hashes.\n", estimate)
 
-	bloomFilter := NewBloomFilter(count, 0.01, p.Fs)
+	// The estimate may be off, so let the filter grow rather than
+	// counting the hashes first. That would mean reading every list
+	// twice, which isn't possible for standard input.
+	bloomFilter := NewScalableBloomFilter(estimate, 0.01, p.Fs)
+	bloomFilter.Role = p.filterRole(opts, bloomFilter.Role)
+	if opts.Has("sidecar") {
+		bloomFilter.Sidecar = &Sidecar{}
+	}
+	if list.Digest != "" {
+		bloomFilter.Digest = list.Digest
+	}
 
 	fmt.Printf("[+] Adding hashes from %s\n", files)
 
-	for _, hashFile := range files {
-		fmt.Printf("%s\n", hashFile)
-		f, err := p.Fs.Open(hashFile)
-		defer f.Close()
+	for _, listFile := range files {
+		fmt.Printf("%s\n", listFile)
+		f, err := openInput(listFile, p.Fs)
 		if err != nil {
 			log.Fatal(err)
 		}
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if !(strings.HasPrefix(line, "#")) && isMD5(line) {
-				bloomFilter.Add(strings.ToLower(line))
-			}
+		if err := list.Read(f, listFile, &bloomFilter); err != nil {
+			log.Fatal(err)
 		}
+		f.Close()
 	}
 
 	fmt.Printf(
-		"[+] Saving %s filter to outfile: %s\n",
-		bloomFilter.ByteSizeHuman,
+		"    Accepted %d, rejected %d, duplicate %d lines.\n",
+		list.Accepted,
+		list.Rejected,
+		list.Duplicates,
+	)
+	if list.Accepted == 0 {
+		fmt.Print("[-] No hashes found\n")
+		os.Exit(1)
+	}
+	fmt.Printf(
+		"[+] Saving %s %s filter to outfile: %s\n",
+		byteSizeHuman(bloomFilter.TotalSize()),
+		bloomFilter.Digest,
 		filterFile,
 	)
 	bloomFilter.Save(filterFile)
+	reportSidecar(&bloomFilter, filterFile)
 	fmt.Print("[+] Done.\n")
 }
 
-// Lookup command parser.
-func (p Parser) Lookup() {
+// Import command parser.
+func (p Parser) Import() {
 	progName := p.Args[0]
-	if len(p.Args) < 4 {
+	opts, args := parseOptions(p.Args[2:], "version", "digest", "role")
+	if len(args) < 3 {
 		usage(progName)
 	}
-	filterFile := p.Args[2]
-	files := p.Args[3:]
-	if !readableFile(filterFile, p.Fs) {
-		fmt.Printf("[-] Unable to open %s for reading\n", filterFile)
+	format := args[0]
+	filterFile := args[1]
+	files := args[2:]
+
+	filters := make(map[string][]string)
+	digestAlg := "md5"
+	role := "known-good"
+	switch format {
+	case "mtree":
+		for _, specFile := range files {
+			fmt.Printf("[+] Reading mtree spec %s\n", specFile)
+			filters[filterFile] = append(filters[filterFile], mtreeDigests(specFile, p.Fs)...)
+		}
+	case "wordpress":
+		if opts.Has("digest") {
+			digestAlg = opts.Get("digest")
+		}
+		labeled := make(map[string][]string)
+		for _, checksumFile := range files {
+			fmt.Printf("[+] Reading WordPress checksums %s\n", checksumFile)
+			checksums, err := wordpressChecksums(checksumFile, opts.Get("version"), digestAlg, p.Fs)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for label, digests := range checksums {
+				labeled[label] = append(labeled[label], digests...)
+			}
+		}
+		filters = wordpressFilterFiles(filterFile, labeled, opts.Has("split"))
+	case "clamav":
+		digestAlg = opts.Get("digest")
+		if digestAlg != "" && !contains(clamavAlgs, digestAlg) {
+			fmt.Printf("[-] ClamAV hash signatures don't use %s digests\n", digestAlg)
+			usage(progName)
+		}
+		var signatures []clamavSignature
+		for _, database := range files {
+			fmt.Printf("[+] Reading ClamAV signatures %s\n", database)
+			signatures = append(signatures, clamavSignatures(database, p.Fs)...)
+		}
+		if digestAlg == "" {
+			var err error
+			if digestAlg, err = clamavDigestAlg(signatures); err != nil {
+				log.Fatal(err)
+			}
+		}
+		digests, skipped := clamavDigests(signatures, digestAlg)
+		if skipped > 0 {
+			fmt.Printf("    Skipped %d signatures not using %s.\n", skipped, digestAlg)
+		}
+		filters[filterFile] = digests
+		role = "known-bad"
+	default:
+		fmt.Printf("Invalid import format: %s\n", format)
 		usage(progName)
 	}
-	bloomFilter := NewBloomFilter(1, 0.01, p.Fs)
-	bloomFilter.Load(filterFile)
+	role = p.filterRole(opts, role)
+
+	// Split imports write filterFile-<version> instead of filterFile, so
+	// targets are only created once there are digests to save in them.
+	var targets []string
+	for target := range filters {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	saved := 0
+	for _, target := range targets {
+		digests := filters[target]
+		if len(digests) == 0 {
+			fmt.Printf("[-] No %s digests found for %s\n", digestAlg, target)
+			continue
+		}
+		if !writeableFile(target, p.Fs) {
+			fmt.Printf("[-] Unable to open %s for writing\n", target)
+			usage(progName)
+		}
+		fmt.Printf("    Counted %d files.\n", len(digests))
+
+		bloomFilter := NewBloomFilter(int32(len(digests)), 0.01, p.Fs)
+		bloomFilter.Digest = digestAlg
+		bloomFilter.Role = role
+		if opts.Has("sidecar") {
+			bloomFilter.Sidecar = &Sidecar{}
+		}
+		for _, digest := range digests {
+			bloomFilter.Add(digest)
+		}
+
+		fmt.Printf(
+			"[+] Saving %s filter to outfile: %s\n",
+			bloomFilter.ByteSizeHuman,
+			target,
+		)
+		bloomFilter.Save(target)
+		reportSidecar(&bloomFilter, target)
+		saved++
+	}
+	if saved == 0 {
+		if len(targets) == 0 {
+			fmt.Printf("[-] No %s digests found\n", digestAlg)
+		}
+		os.Exit(1)
+	}
+	fmt.Print("[+] Done.\n")
+}
+
+// Lookup command parser.
+func (p Parser) Lookup() {
+	progName := p.Args[0]
+	opts, args := parseOptions(p.Args[2:], append(scanValued, "set", "html", "sarif")...)
+	var filterFiles, files []string
+	if opts.Has("installed") || opts.Has("set") {
+		filterFiles = p.installedFilterFiles(opts)
+		files = scanRoots(args, opts, progName)
+	} else {
+		if len(args) < 1 {
+			usage(progName)
+		}
+		// Several filters, of any digests, may be given separated by
+		// commas.
+		filterFiles = strings.Split(args[0], ",")
+		files = scanRoots(args[1:], opts, progName)
+	}
+	out := messageOutput(opts)
+	set := &FilterSet{Scanner: p.newScanner(opts)}
+	set.Scanner.Out = out
+	set.Filters = p.loadFilters(filterFiles, opts)
+	p.overrideRoles(set.Filters, opts)
+	set.Scanner.DetectTypes = true
+	set.Cache = p.hashCache(opts)
+	if opts.Has("summary") {
+		set.Summary = &treeSummary{}
+	}
+	if opts.Has("html") {
+		if !writeableFile(opts.Get("html"), p.Fs) {
+			fmt.Fprintf(out, "[-] Unable to open %s for writing\n", opts.Get("html"))
+			usage(progName)
+		}
+		set.Report = newHTMLReport(files, set.Filters)
+	}
+	if opts.Has("sarif") {
+		if !writeableFile(opts.Get("sarif"), p.Fs) {
+			fmt.Fprintf(out, "[-] Unable to open %s for writing\n", opts.Get("sarif"))
+			usage(progName)
+		}
+		set.Sarif = newSarifLog(set.Filters)
+	}
+	if opts.Has("print0") {
+		set.Print0 = os.Stdout
+	}
 	for _, file := range files {
-		bloomFilter.LookupHashes(file)
+		if file != stdinPath {
+			set.LookupHashes(file)
+			continue
+		}
+		// Paths to look up may be piped in from find or locate.
+		if err := readPaths(os.Stdin, opts.Has("null"), set.LookupHashes); err != nil {
+			log.Fatal(err)
+		}
+	}
+	saveHashCache(set.Cache, set.Scanner.Out)
+	if set.Report != nil {
+		if err := set.Report.Save(opts.Get("html"), p.Fs); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(out, "[+] Saved HTML report: %s\n", opts.Get("html"))
+	}
+	if set.Sarif != nil {
+		if err := set.Sarif.Save(opts.Get("sarif"), p.Fs); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(out, "[+] Saved SARIF log: %s\n", opts.Get("sarif"))
+	}
+	if set.Summary != nil && opts.Has("json") {
+		encoded, err := json.MarshalIndent(set.Summary.Root(), "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(out, "%s\n", encoded)
+		return
+	}
+	if set.Summary != nil {
+		set.Summary.Print(out)
+	}
+	fmt.Fprintf(
+		out,
+		"[+] %d known-good, %d modified, %d unknown, %d known-bad\n",
+		set.Verdicts[verdictKnownGood],
+		set.Verdicts[verdictModified],
+		set.Verdicts[verdictUnknown],
+		set.Verdicts[verdictKnownBad],
+	)
+	if bad := set.Verdicts[verdictKnownBad]; bad > 0 {
+		fmt.Fprintf(out, "[!] Found %d known-bad files\n", bad)
+	}
+	if modified := set.Verdicts[verdictModified]; modified > 0 {
+		fmt.Fprintf(out, "[!] Found %d modified files\n", modified)
+	}
+}
+
+// reportSidecar says where the sidecar of a filter saved to path went,
+// if it has one.
+func reportSidecar(bloomFilter *BloomFilter, path string) {
+	if bloomFilter.Sidecar != nil {
+		fmt.Printf(
+			"[+] Saved %d digests to sidecar: %s\n",
+			bloomFilter.Sidecar.Len(),
+			path+sidecarSuffix,
+		)
+	}
+}
+
+// messageOutput returns where a command prints its messages: standard
+// output, or standard error with --print0, which keeps standard output
+// for the paths of unknown files, for xargs -0.
+func messageOutput(opts options) io.Writer {
+	if opts.Has("print0") {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// loadFilters loads the filter files given to a lookup. With --confirm,
+// the sidecar of each filter is loaded too, so matches are confirmed.
+func (p Parser) loadFilters(filterFiles []string, opts options) []*BloomFilter {
+	var filters []*BloomFilter
+	for _, filterFile := range filterFiles {
+		if !readableFile(filterFile, p.Fs) {
+			fmt.Printf("[-] Unable to open %s for reading\n", filterFile)
+			usage(p.Args[0])
+		}
+		bloomFilter := NewBloomFilter(1, 0.01, p.Fs)
+		bloomFilter.Load(filterFile)
+		if opts.Has("confirm") {
+			if err := bloomFilter.LoadSidecar(filterFile); err != nil {
+				fmt.Fprintf(messageOutput(opts), "[-] Matches in %s can't be confirmed: %v\n", bloomFilter.Name, err)
+			}
+		}
+		filters = append(filters, &bloomFilter)
+	}
+	return filters
+}
+
+// overrideRoles applies the roles given to filters looked up in:
+// --known-bad treats every filter as known-bad, and roles given to
+// installed filters in installed.json override the roles stored in the
+// filters.
+func (p Parser) overrideRoles(filters []*BloomFilter, opts options) {
+	roles := make(map[string]string)
+	if opts.Has("installed") || opts.Has("set") {
+		roles = installedRoles(p.Fs)
+	}
+	for _, bloomFilter := range filters {
+		if role, ok := roles[bloomFilter.Name]; ok {
+			bloomFilter.setRole(role)
+		}
+		if opts.Has("known-bad") {
+			bloomFilter.setRole("known-bad")
+		}
+	}
+}
+
+// filterRole returns the role named with --role for a new filter, or
+// role if none was.
+func (p Parser) filterRole(opts options, role string) string {
+	if !opts.Has("role") {
+		return role
+	}
+	if !contains(filterRoles, opts.Get("role")) {
+		fmt.Printf("[-] Invalid role: %s\n", opts.Get("role"))
+		usage(p.Args[0])
+	}
+	return opts.Get("role")
+}
+
+// installedFilterFiles returns the installed filters to look files up
+// in: every one with --installed, or those of a set named in
+// config.json with --set.
+func (p Parser) installedFilterFiles(opts options) []string {
+	var patterns []string
+	if opts.Has("set") {
+		name := opts.Get("set")
+		var ok bool
+		patterns, ok = getConfig().Sets[name]
+		if !ok || len(patterns) == 0 {
+			fmt.Printf("[-] Unknown filter set: %s\n", name)
+			usage(p.Args[0])
+		}
+	}
+	filterFiles, err := installedFilters(p.Fs, patterns)
+	if err != nil {
+		fmt.Printf("[-] Unable to list installed filters: %v\n", err)
+		os.Exit(1)
+	}
+	if len(filterFiles) == 0 {
+		fmt.Print("[-] No installed filters found\n")
+		os.Exit(1)
+	}
+	if !opts.Has("json") {
+		fmt.Fprintf(messageOutput(opts), "[+] Looking up files in %d filters\n", len(filterFiles))
+	}
+	return filterFiles
+}
+
+// Identify command parser.
+func (p Parser) Identify() {
+	progName := p.Args[0]
+	opts, args := parseOptions(p.Args[2:], append(scanValued, "set", "top")...)
+	var filterFiles, files []string
+	if opts.Has("installed") || opts.Has("set") {
+		filterFiles = p.installedFilterFiles(opts)
+		files = scanRoots(args, opts, progName)
+	} else {
+		if len(args) < 2 {
+			usage(progName)
+		}
+		files = args[:1]
+		filterFiles = args[1:]
+	}
+	top := 10
+	if opts.Has("top") {
+		n, err := strconv.Atoi(opts.Get("top"))
+		if err != nil || n < 1 {
+			fmt.Printf("[-] Invalid top: %s\n", opts.Get("top"))
+			usage(progName)
+		}
+		top = n
+	}
+	set := &FilterSet{Scanner: p.newScanner(opts)}
+	set.Filters = p.loadFilters(filterFiles, opts)
+	set.Cache = p.hashCache(opts)
+
+	fmt.Printf("[+] Scoring %s against %d filters\n", strings.Join(files, ", "), len(set.Filters))
+	result := set.Identify(files)
+	saveHashCache(set.Cache, set.Scanner.Out)
+	fmt.Printf("Scanned %d files, %d in none of the filters.\n", result.Files, result.Unmatched)
+	if result.Files == 0 || result.Best == 0 {
+		fmt.Print("[-] No files matched any filter\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s%10s%10s\n", "Filter", "Matched", "Score")
+	fmt.Printf("%s\n", strings.Repeat("-", 50))
+	for i, score := range result.Scores {
+		if i >= top || score.Matched == 0 {
+			break
+		}
+		fmt.Printf("%-30s%10d%9.1f%%\n", score.Name, score.Matched, score.Fraction(result.Files)*100)
+	}
+
+	var best []string
+	for _, score := range result.Scores[:result.Best] {
+		best = append(best, score.Name)
+	}
+	fmt.Printf(
+		"[+] Most likely: %s (%.1f%% of files)\n",
+		strings.Join(best, ", "),
+		result.Scores[0].Fraction(result.Files)*100,
+	)
+	if len(result.Mixed) > 0 {
+		fmt.Print("[!] Mixed versions: files match other filters but not the most likely\n")
+		for _, score := range result.Mixed {
+			fmt.Printf("    %d files match %s\n", score.Elsewhere, score.Name)
+		}
+	}
+}
+
+// Check command parser.
+func (p Parser) Check() {
+	progName := p.Args[0]
+	opts, args := parseOptions(p.Args[2:], "column", "set")
+	var filterFiles []string
+	if opts.Has("installed") || opts.Has("set") {
+		filterFiles = p.installedFilterFiles(opts)
+	} else {
+		if len(args) < 1 {
+			usage(progName)
+		}
+		filterFiles = strings.Split(args[0], ",")
+		args = args[1:]
+	}
+	checker := hashChecker{JSON: opts.Has("json")}
+	if opts.Has("column") {
+		column, err := strconv.Atoi(opts.Get("column"))
+		if err != nil || column < 1 {
+			fmt.Printf("[-] Invalid column: %s\n", opts.Get("column"))
+			usage(progName)
+		}
+		checker.Column = column
+	}
+	checker.Set = &FilterSet{Filters: p.loadFilters(filterFiles, opts)}
+	p.overrideRoles(checker.Set.Filters, opts)
+
+	// Digests are read from standard input when none are given.
+	if len(args) == 0 {
+		args = []string{stdinPath}
+	}
+	for _, arg := range args {
+		if arg != stdinPath {
+			checker.CheckLine(arg)
+			continue
+		}
+		f, err := openInput(stdinPath, p.Fs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := checker.Read(f); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+	if checker.JSON {
+		return
+	}
+	fmt.Printf(
+		"[+] %d known-good, %d unknown, %d known-bad\n",
+		checker.Verdicts[verdictKnownGood],
+		checker.Verdicts[verdictUnknown],
+		checker.Verdicts[verdictKnownBad],
+	)
+	if bad := checker.Verdicts[verdictKnownBad]; bad > 0 {
+		fmt.Printf("[!] Found %d known-bad digests\n", bad)
 	}
 }
 
@@ -249,10 +1091,16 @@ func (p Parser) Parse() {
 	switch command {
 	case "calculate":
 		p.Calculate()
+	case "check":
+		p.Check()
 	case "filters":
 		p.Filters()
 	case "fromfile":
 		p.FromFile()
+	case "identify":
+		p.Identify()
+	case "import":
+		p.Import()
 	case "lookup":
 		p.Lookup()
 	default:
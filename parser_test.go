@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/md5"
 	"fmt"
 	"log"
@@ -207,3 +209,30 @@ func TestCalculateAndLookup(t *testing.T) {
 	parser = Parser{Args: lookupArgs, Fs: fs}
 	parser.Lookup()
 }
+
+func TestFromFileCompressed(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/data/"
+	fs.MkdirAll(fakeDir, 0755)
+	digests := []string{
+		"0CC175B9C0F1B6A831C399E269772661",
+		"92eb5ffee6ae2fec3ad71c777531578f",
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("# comment\n" + strings.Join(digests, "\n") + "\nnot a hash\n"))
+	gz.Close()
+	afero.WriteFile(fs, fakeDir+"hashes.txt.gz", buf.Bytes(), 0644)
+
+	args := []string{"mdd", "fromfile", fakeDir + "filterfile", fakeDir + "hashes.txt.gz"}
+	parser := Parser{Args: args, Fs: fs}
+	parser.FromFile()
+
+	bloomFilter := NewBloomFilter(1, 0.01, fs)
+	bloomFilter.Load(fakeDir + "filterfile")
+	for _, digest := range digests {
+		if !bloomFilter.Lookup(strings.ToLower(digest)) {
+			t.Errorf("FromFile: expected to find: %s", digest)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"html/template"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// htmlReport collects the results of a lookup into a single HTML file
+// that can be handed on, with no scripts, styles or images to fetch.
+type htmlReport struct {
+	Generated time.Time
+	Roots     []string
+	Filters   []reportFilter
+	Verdicts  map[string]int
+	Files     int
+	// Unknown lists every file that isn't known-good, in the order
+	// found.
+	Unknown []reportFile
+	// Tree counts verdicts by directory.
+	Tree treeSummary
+	// owners caches user names by user id.
+	owners map[uint32]string
+}
+
+// reportFilter describes a filter looked files up in.
+type reportFilter struct {
+	Name   string
+	Digest string
+	Role   string
+	Size   string
+	// FPRate is the filter's estimated false positive rate.
+	FPRate float64
+	// Confirmed is set when matches are confirmed by a sidecar.
+	Confirmed bool
+}
+
+// reportFile describes a file that isn't known-good.
+type reportFile struct {
+	Path    string
+	Verdict string
+	Type    string
+	Size    int64
+	ModTime time.Time
+	Owner   string
+	Digest  string
+}
+
+// newHTMLReport starts a report on a lookup of roots in filters.
+func newHTMLReport(roots []string, filters []*BloomFilter) *htmlReport {
+	r := &htmlReport{
+		Generated: time.Now(),
+		Roots:     roots,
+		Verdicts:  make(map[string]int),
+		owners:    make(map[uint32]string),
+	}
+	for _, bf := range filters {
+		r.Filters = append(r.Filters, reportFilter{
+			Name:      bf.Name,
+			Digest:    digestKind{bf.Digest, bf.Normalize}.String(),
+			Role:      bf.Role,
+			Size:      byteSizeHuman(bf.TotalSize()),
+			FPRate:    bf.EstimatedFPRate(),
+			Confirmed: bf.Sidecar != nil,
+		})
+	}
+	return r
+}
+
+// Add records the verdict on a file, whose digest is given as
+// "alg:digest".
+func (r *htmlReport) Add(f *scannedFile, verdict, digest string) {
+	r.Files++
+	r.Verdicts[verdict]++
+	r.Tree.Add(f.Path, verdict, f.Type)
+	if verdict == verdictKnownGood {
+		return
+	}
+	r.Unknown = append(r.Unknown, reportFile{
+		Path:    f.Path,
+		Verdict: verdict,
+		Type:    f.Type,
+		Size:    f.Info.Size(),
+		ModTime: f.Info.ModTime(),
+		Owner:   r.owner(f),
+		Digest:  digest,
+	})
+}
+
+// owner returns the name of the user owning a file, its user id if the
+// user is unknown, or "" if the filesystem doesn't say.
+func (r *htmlReport) owner(f *scannedFile) string {
+	uid, ok := fileOwner(f.Info)
+	if !ok {
+		return ""
+	}
+	name, ok := r.owners[uid]
+	if !ok {
+		name = strconv.FormatUint(uint64(uid), 10)
+		if u, err := user.LookupId(name); err == nil {
+			name = u.Username
+		}
+		r.owners[uid] = name
+	}
+	return name
+}
+
+// Save writes the report to path.
+func (r *htmlReport) Save(path string, fs afero.Fs) error {
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := reportTemplate.Execute(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(rate float64) string {
+		return strconv.FormatFloat(rate*100, 'g', 3, 64) + "%"
+	},
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>mdd lookup report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.2em; margin-top: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #eee; }
+#unknown th { cursor: pointer; }
+#unknown th:after { content: " \2195"; color: #999; }
+td.num { text-align: right; }
+code, .path { font-family: monospace; word-break: break-all; }
+.known-bad, .flagged { color: #b00; font-weight: bold; }
+.modified { color: #b60; }
+details { margin-left: 1.2em; }
+summary { cursor: pointer; }
+.leaf { margin-left: 2.4em; }
+.counts { color: #666; }
+</style>
+</head>
+<body>
+<h1>mdd lookup report</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}} for {{join .Roots ", "}}</p>
+<table>
+<tr><th>Files</th><th>Known-good</th><th>Modified</th><th>Unknown</th><th>Known-bad</th></tr>
+<tr>
+<td class="num">{{.Files}}</td>
+<td class="num">{{index .Verdicts "known-good"}}</td>
+<td class="num">{{index .Verdicts "modified"}}</td>
+<td class="num">{{index .Verdicts "unknown"}}</td>
+<td class="num">{{index .Verdicts "known-bad"}}</td>
+</tr>
+</table>
+
+<h2>Filters</h2>
+<table>
+<tr><th>Filter</th><th>Digest</th><th>Role</th><th>Size</th><th>Estimated false positive rate</th></tr>
+{{range .Filters}}<tr>
+<td>{{.Name}}</td>
+<td>{{.Digest}}</td>
+<td>{{.Role}}</td>
+<td class="num">{{.Size}}</td>
+<td class="num">{{if .Confirmed}}0 (confirmed by sidecar){{else}}{{percent .FPRate}}{{end}}</td>
+</tr>
+{{end}}</table>
+
+<h2>Files not known to be good</h2>
+{{if .Unknown}}<table id="unknown">
+<thead><tr><th>Path</th><th>Verdict</th><th>Type</th><th>Size</th><th>Modified</th><th>Owner</th><th>Digest</th></tr></thead>
+<tbody>
+{{range .Unknown}}<tr>
+<td class="path">{{.Path}}</td>
+<td class="{{.Verdict}}">{{.Verdict}}</td>
+<td>{{.Type}}</td>
+<td class="num" data-sort="{{.Size}}">{{.Size}}</td>
+<td data-sort="{{.ModTime.Unix}}">{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.Owner}}</td>
+<td><code>{{.Digest}}</code></td>
+</tr>
+{{end}}</tbody>
+</table>
+{{else}}<p>Every file is known-good.</p>
+{{end}}
+<h2>Directories</h2>
+{{template "dir" .Tree.Root}}
+
+<script>
+(function () {
+  var table = document.getElementById("unknown");
+  if (!table) {
+    return;
+  }
+  var headers = table.tHead.rows[0].cells;
+  for (var i = 0; i < headers.length; i++) {
+    headers[i].addEventListener("click", sortBy(i));
+  }
+  function value(row, column) {
+    var cell = row.cells[column];
+    var sort = cell.getAttribute("data-sort");
+    return sort === null ? cell.textContent : Number(sort);
+  }
+  function sortBy(column) {
+    var ascending = true;
+    return function () {
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      rows.sort(function (a, b) {
+        var x = value(a, column), y = value(b, column);
+        var order = x < y ? -1 : x > y ? 1 : 0;
+        return ascending ? order : -order;
+      });
+      ascending = !ascending;
+      for (var i = 0; i < rows.length; i++) {
+        body.appendChild(rows[i]);
+      }
+    };
+  }
+})();
+</script>
+</body>
+</html>
+{{define "counts"}}<span class="counts">{{.Files}} files, {{printf "%.1f" .PercentKnown}}% known
+{{- if .Unknown}}, {{.Unknown}} unknown{{end}}
+{{- if .Modified}}, {{.Modified}} modified{{end}}
+{{- if .KnownBad}}, {{.KnownBad}} known-bad{{end}}
+{{- if .UnknownExecutables}}, {{.UnknownExecutables}} unknown executables{{end}}</span>{{end}}
+{{define "dir"}}{{if .Children}}<details{{if or .Unknown .KnownBad .Modified}} open{{end}}>
+<summary><span class="path{{if .Flagged}} flagged{{end}}">{{.Label}}</span> {{template "counts" .}}</summary>
+{{range .Children}}{{template "dir" .}}{{end}}</details>
+{{else}}<div class="leaf"><span class="path{{if .Flagged}} flagged{{end}}">{{.Label}}</span> {{template "counts" .}}{{if .Collapsed}} <span class="counts">(all known)</span>{{end}}</div>
+{{end}}{{end}}
+`))
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestHTMLReport(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "/var/www/"
+	fs.MkdirAll(fakeDir+"uploads", 0755)
+	afero.WriteFile(fs, fakeDir+"index.php", []byte("<?php\necho 1;\n"), 0644)
+	afero.WriteFile(fs, fakeDir+"uploads/<b>.php", []byte("<?php\necho 2;\n"), 0644)
+
+	bloomFilter := NewBloomFilter(10, 0.01, fs)
+	bloomFilter.Name = "wordpress"
+	digest, _ := hashReader(strings.NewReader("<?php\necho 1;\n"), "md5")
+	bloomFilter.Add(digest)
+	set := &FilterSet{
+		Filters: []*BloomFilter{&bloomFilter},
+		Scanner: NewScanner(fs),
+	}
+	set.Report = newHTMLReport([]string{fakeDir}, set.Filters)
+	set.Scanner.DetectTypes = true
+	set.LookupHashes(fakeDir)
+
+	report := set.Report
+	if report.Files != 2 || report.Verdicts[verdictKnownGood] != 1 {
+		t.Errorf("htmlReport: Add: expected: 2 files, 1 known-good actual: %d files, %v", report.Files, report.Verdicts)
+	}
+	if len(report.Unknown) != 1 || report.Unknown[0].Path != fakeDir+"uploads/<b>.php" {
+		t.Fatalf("htmlReport: Add: expected the unknown upload actual: %+v", report.Unknown)
+	}
+	unknownDigest, _ := hashReader(strings.NewReader("<?php\necho 2;\n"), "md5")
+	if report.Unknown[0].Digest != "md5:"+unknownDigest {
+		t.Errorf("htmlReport: Add: expected: md5:%s actual: %s", unknownDigest, report.Unknown[0].Digest)
+	}
+
+	if err := report.Save("/tmp/report.html", fs); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := afero.ReadFile(fs, "/tmp/report.html")
+	html := string(content)
+	for _, expected := range []string{"wordpress", "uploads/&lt;b&gt;.php", "<details open>", unknownDigest} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("htmlReport: Save: expected the report to contain %q", expected)
+		}
+	}
+	for _, external := range []string{"src=", "href=", "<b>.php"} {
+		if strings.Contains(html, external) {
+			t.Errorf("htmlReport: Save: expected no %q in the report", external)
+		}
+	}
+}
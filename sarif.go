@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// SARIF 2.1.0 is the format code scanning services ingest findings in.
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://docs.oasis-open.org/sarif/sarif/v2.1.0/errata01/os/schemas/sarif-schema-2.1.0.json"
+)
+
+// sarifRules are the kinds of finding a lookup reports, one for each
+// verdict worth looking into.
+var sarifRules = []sarifRule{
+	{
+		ID:                   "unknown-file",
+		ShortDescription:     sarifMessage{"File not held by any filter"},
+		DefaultConfiguration: sarifConfiguration{"warning"},
+	},
+	{
+		ID:                   "modified-file",
+		ShortDescription:     sarifMessage{"Known file with changed contents"},
+		DefaultConfiguration: sarifConfiguration{"warning"},
+	},
+	{
+		ID:                   "known-bad-file",
+		ShortDescription:     sarifMessage{"File held by a known-bad filter"},
+		DefaultConfiguration: sarifConfiguration{"error"},
+	},
+}
+
+// sarifRuleIDs maps verdicts to the rule reported for them.
+var sarifRuleIDs = map[string]string{
+	verdictUnknown:  "unknown-file",
+	verdictModified: "modified-file",
+	verdictKnownBad: "known-bad-file",
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+
+	// badOnly is set when every filter is known-bad, so that unknown
+	// files are left out as they are from the usual output.
+	badOnly bool
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+	// Properties lists the filters files were looked up in.
+	Properties sarifRunProperties `json:"properties"`
+}
+
+type sarifRunProperties struct {
+	Filters []string `json:"filters"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string           `json:"ruleId"`
+	RuleIndex  int              `json:"ruleIndex"`
+	Level      string           `json:"level"`
+	Message    sarifMessage     `json:"message"`
+	Locations  []sarifLocation  `json:"locations"`
+	Properties sarifFileDetails `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifFileDetails are the properties of a result.
+type sarifFileDetails struct {
+	Digest string `json:"digest"`
+	// Filters names the filters that decided the verdict.
+	Filters []string `json:"filters,omitempty"`
+	Type    string   `json:"type,omitempty"`
+}
+
+// newSarifLog starts a SARIF log for a lookup in filters.
+func newSarifLog(filters []*BloomFilter) *sarifLog {
+	run := sarifRun{
+		Tool: sarifTool{sarifDriver{
+			Name:           "mdd",
+			InformationURI: "https://github.com/roberson-io/mdd",
+			Rules:          sarifRules,
+		}},
+		Results: []sarifResult{},
+	}
+	l := &sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		badOnly: true,
+	}
+	for _, bf := range filters {
+		run.Properties.Filters = append(run.Properties.Filters, bf.Name)
+		l.badOnly = l.badOnly && bf.Role == "known-bad"
+	}
+	l.Runs = []sarifRun{run}
+	return l
+}
+
+// sarifURI returns the location of a file as a URI: relative paths stay
+// relative, so code scanning services can resolve them against the
+// checkout, and absolute ones become file URIs.
+func sarifURI(path string) string {
+	u := url.URL{Path: filepath.ToSlash(path)}
+	if filepath.IsAbs(path) {
+		u.Scheme = "file"
+	}
+	return u.String()
+}
+
+// Add records a result for a file that is unknown, modified or
+// known-bad, decided by the filters in matched. Other files are left
+// out, as are unknown files when every filter is known-bad. The digest
+// is given as "alg:digest".
+func (l *sarifLog) Add(f *scannedFile, verdict string, matched []string, digest string) {
+	ruleID, ok := sarifRuleIDs[verdict]
+	if !ok || verdict == verdictUnknown && l.badOnly {
+		return
+	}
+	var rule sarifRule
+	ruleIndex := 0
+	for i, r := range sarifRules {
+		if r.ID == ruleID {
+			rule, ruleIndex = r, i
+		}
+	}
+	message := fmt.Sprintf("%s is %s", f.Path, verdict)
+	switch {
+	case verdict == verdictModified:
+		message += " (changed from " + strings.Join(matched, ", ") + ")"
+	case len(matched) > 0:
+		message += " (matched " + strings.Join(matched, ", ") + ")"
+	}
+	run := &l.Runs[0]
+	run.Results = append(run.Results, sarifResult{
+		RuleID:    ruleID,
+		RuleIndex: ruleIndex,
+		Level:     rule.DefaultConfiguration.Level,
+		Message:   sarifMessage{message},
+		Locations: []sarifLocation{{sarifPhysicalLocation{
+			sarifArtifactLocation{sarifURI(f.Path)},
+		}}},
+		Properties: sarifFileDetails{
+			Digest:  digest,
+			Filters: matched,
+			Type:    f.Type,
+		},
+	})
+}
+
+// Save writes the log to path.
+func (l *sarifLog) Save(path string, fs afero.Fs) error {
+	encoded, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(fs, path, append(encoded, '\n'), 0644)
+}
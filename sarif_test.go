@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestSarifLog(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fakeDir := "build/"
+	fs.MkdirAll(fakeDir+"bin", 0755)
+	afero.WriteFile(fs, fakeDir+"bin/app", []byte("app"), 0755)
+	afero.WriteFile(fs, fakeDir+"bin/miner", []byte("miner"), 0755)
+	afero.WriteFile(fs, fakeDir+"bin/new tool", []byte("new"), 0755)
+
+	good := NewBloomFilter(10, 0.01, fs)
+	good.Name = "release"
+	bad := NewBloomFilter(10, 0.01, fs)
+	bad.Name = "malware"
+	bad.Role = "known-bad"
+	good.Add(md5Hex("app"))
+	bad.Add(md5Hex("miner"))
+	set := &FilterSet{
+		Filters: []*BloomFilter{&good, &bad},
+		Scanner: NewScanner(fs),
+	}
+	set.Sarif = newSarifLog(set.Filters)
+	set.LookupHashes(fakeDir)
+	if err := set.Sarif.Save("/tmp/results.sarif", fs); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := afero.ReadFile(fs, "/tmp/results.sarif")
+	var parsed sarifLog
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		t.Fatalf("sarifLog: Save: %v", err)
+	}
+	if parsed.Version != "2.1.0" || len(parsed.Runs) != 1 {
+		t.Fatalf("sarifLog: Save: expected one SARIF 2.1.0 run actual: %s with %d runs", parsed.Version, len(parsed.Runs))
+	}
+	results := parsed.Runs[0].Results
+	if len(results) != 2 {
+		t.Fatalf("sarifLog: Add: expected: 2 results actual: %d", len(results))
+	}
+	expected := []struct {
+		ruleID, level, uri, digest, filters string
+	}{
+		{"known-bad-file", "error", "build/bin/miner", "md5:" + md5Hex("miner"), "malware"},
+		{"unknown-file", "warning", "build/bin/new%20tool", "md5:" + md5Hex("new"), ""},
+	}
+	for i, result := range results {
+		uri := result.Locations[0].PhysicalLocation.ArtifactLocation.URI
+		actual := []string{result.RuleID, result.Level, uri, result.Properties.Digest, strings.Join(result.Properties.Filters, ",")}
+		want := []string{expected[i].ruleID, expected[i].level, expected[i].uri, expected[i].digest, expected[i].filters}
+		if strings.Join(actual, " ") != strings.Join(want, " ") {
+			t.Errorf("sarifLog: Add: expected: %v actual: %v", want, actual)
+		}
+		if sarifRules[result.RuleIndex].ID != result.RuleID {
+			t.Errorf("sarifLog: Add: expected rule %d to be %s", result.RuleIndex, result.RuleID)
+		}
+	}
+	if uri := sarifURI("/srv/app/a b"); uri != "file:///srv/app/a%20b" {
+		t.Errorf("sarifURI: expected: file:///srv/app/a%%20b actual: %s", uri)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// scannedFile is a file found by a Scanner.
+type scannedFile struct {
+	// Path is the file's path. Archive members are reported as
+	// archive.zip!inner/path.
+	Path string
+	// Root is the path the scan started from.
+	Root string
+	// Rel is Path relative to Root, or its base name when Root is a
+	// single file. Members of archives scanned with FromArchive are
+	// relative to the archive.
+	Rel  string
+	Info os.FileInfo
+	// Reader reads the file's contents. It is nil for directories and
+	// for files that couldn't be opened.
+	Reader io.Reader
+	// Type is the detected type of the file's contents, when the
+	// scanner detects types.
+	Type string
+	// Err says why a file couldn't be opened.
+	Err error
+}
+
+// scanFunc is called for each file found by a Scanner. Returning an
+// error stops the scan.
+type scanFunc func(f *scannedFile) error
+
+// Scanner walks the files to be hashed when building or checking a
+// filter.
+type Scanner struct {
+	Fs afero.Fs
+	// Dirs makes the scanner report directories as well as files.
+	Dirs bool
+	// Archives makes the scanner look inside zip, jar, war, tar and
+	// compressed tar archives, reporting their members as well as the
+	// archives themselves.
+	Archives bool
+	// MaxDepth limits how deeply archives within archives are opened.
+	MaxDepth int
+	// MaxSize limits how many bytes are decompressed from an archive,
+	// including any archives within it.
+	MaxSize int64
+	// FromArchive makes the scanner treat the roots it walks as
+	// archives, reporting only their members.
+	FromArchive bool
+	// StripComponents removes leading directories from the names of
+	// the members of archives, as tar --strip-components does. Members
+	// nested less deeply are skipped. Archives within archives keep
+	// their members' names.
+	StripComponents int
+	// Filter limits which files are reported.
+	Filter scanFilter
+	// DetectTypes makes the scanner detect the type of each file's
+	// contents from its first bytes.
+	DetectTypes bool
+	// FollowSymlinks makes the scanner follow symlinks to files and
+	// directories, skipping directories it has already scanned.
+	FollowSymlinks bool
+	// ReportSymlinks prints symlinks that point outside the scan root.
+	ReportSymlinks bool
+	// OneFileSystem keeps the scanner from crossing into directories
+	// on other filesystems than the root's.
+	OneFileSystem bool
+	// ReportSpecial prints named pipes, sockets and devices found.
+	ReportSpecial bool
+	// Types, when there are any, limits the scan to files of these
+	// detected types. "executable" stands for any type that could be
+	// run.
+	Types []string
+	// Out is where findings and problems met during the scan are
+	// printed, and the results of a FilterSet using the scanner.
+	Out io.Writer
+}
+
+// Defaults for Scanner.MaxDepth and Scanner.MaxSize.
+const (
+	defaultArchiveDepth = 3
+	defaultArchiveSize  = 1 << 30
+)
+
+// NewScanner constructs a Scanner for fs with the default archive
+// limits. Archive scanning is off until Archives is set, and ignore
+// files aren't read until Filter.IgnoreFile is set.
+func NewScanner(fs afero.Fs) *Scanner {
+	return &Scanner{
+		Fs:       fs,
+		MaxDepth: defaultArchiveDepth,
+		MaxSize:  defaultArchiveSize,
+		Out:      os.Stdout,
+	}
+}
+
+// specialModes are the file modes reported by ReportSpecial.
+const specialModes = os.ModeNamedPipe | os.ModeSocket | os.ModeDevice | os.ModeCharDevice
+
+// visit walks root, calling fn for each regular file the scan covers,
+// and for each directory if Dirs is set. Files and directories left
+// out by the filter are skipped. Findings about symlinks and special
+// files are printed only if report is set, so that counting files
+// doesn't print them twice.
+func (s *Scanner) visit(root string, report bool, fn func(path string, info os.FileInfo) error) error {
+	var reportTo io.Writer
+	if report {
+		reportTo = s.Out
+	}
+	w := &scanWalk{
+		Scanner: s,
+		root:    root,
+		report:  report,
+		ignores: newIgnoreSet(s.Fs, root, &s.Filter, reportTo),
+		visited: make(map[string]bool),
+		fn:      fn,
+	}
+	if info, err := s.Fs.Stat(root); err == nil {
+		w.rootDev, _, w.haveRootDev = fileID(info)
+	}
+	err := afero.Walk(s.Fs, root, w.walkFn)
+	w.ignores.printSkipped()
+	return err
+}
+
+// scanWalk is the state of one walk by visit.
+type scanWalk struct {
+	*Scanner
+	root    string
+	report  bool
+	ignores *ignoreSet
+	// visited holds the directories walked so far when following
+	// symlinks, so loops and repeats are skipped.
+	visited     map[string]bool
+	rootDev     uint64
+	haveRootDev bool
+	fn          func(path string, info os.FileInfo) error
+}
+
+func (w *scanWalk) walkFn(path string, info os.FileInfo, err error) error {
+	if err != nil {
+		fmt.Fprintf(w.Out, "Error accessing path %q: %v\n", path, err)
+		return err
+	}
+	if rel, err := filepath.Rel(w.root, path); err == nil && rel != "." {
+		if w.ignores.excluded(filepath.ToSlash(rel), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+	}
+	mode := info.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return w.symlink(path)
+	case info.IsDir():
+		if dev, _, ok := fileID(info); ok && w.OneFileSystem && w.haveRootDev && dev != w.rootDev {
+			if w.report {
+				fmt.Fprintf(w.Out, "Not crossing into %s: on another filesystem\n", path)
+			}
+			return filepath.SkipDir
+		}
+		if w.FollowSymlinks {
+			// Directories reached again through a symlink to one of
+			// their parents have been scanned already.
+			key := w.dirKey(path, info)
+			if w.visited[key] {
+				return filepath.SkipDir
+			}
+			w.visited[key] = true
+		}
+		if w.Dirs {
+			return w.fn(path, info)
+		}
+		return nil
+	case mode&specialModes != 0:
+		if w.report && w.ReportSpecial {
+			fmt.Fprintf(w.Out, "[!] %s is a %s\n", path, specialKind(mode))
+		}
+		return nil
+	}
+	// We only care about files.
+	if !mode.IsRegular() || !w.Filter.keepFile(info) {
+		return nil
+	}
+	return w.fn(path, info)
+}
+
+// symlink reports a symlink that points outside the root, if asked to,
+// and follows it if asked to.
+func (w *scanWalk) symlink(path string) error {
+	if w.report && w.ReportSymlinks {
+		if target, err := readlink(w.Fs, path); err == nil {
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if outsideRoot(w.root, target) {
+				fmt.Fprintf(w.Out, "[!] %s is a symlink to %s, outside %s\n", path, filepath.Clean(target), w.root)
+			}
+		}
+	}
+	if !w.FollowSymlinks {
+		return nil
+	}
+	info, err := w.Fs.Stat(path)
+	if err != nil {
+		if w.report {
+			fmt.Fprintf(w.Out, "Not following symlink %s: %v\n", path, err)
+		}
+		return nil
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		// Filesystems that can't resolve links stat them as links.
+		return nil
+	}
+	if !info.IsDir() {
+		return w.walkFn(path, info, nil)
+	}
+	if w.visited[w.dirKey(path, info)] {
+		if w.report {
+			fmt.Fprintf(w.Out, "Not following symlink %s: directory already scanned\n", path)
+		}
+		return nil
+	}
+	// afero.Walk won't descend through a symlink, so walk what's in
+	// the directory from here.
+	if err := w.walkFn(path, info, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	dir, err := w.Fs.Open(path)
+	if err != nil {
+		return nil
+	}
+	names, _ := dir.Readdirnames(-1)
+	dir.Close()
+	sort.Strings(names)
+	for _, name := range names {
+		if err := afero.Walk(w.Fs, filepath.Join(path, name), w.walkFn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirKey identifies a directory however it was reached, by device and
+// inode where the platform has them and by its real path otherwise.
+func (w *scanWalk) dirKey(path string, info os.FileInfo) string {
+	if dev, ino, ok := fileID(info); ok {
+		return fmt.Sprintf("%d:%d", dev, ino)
+	}
+	if _, ok := w.Fs.(*afero.OsFs); ok {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return real
+		}
+	}
+	return filepath.Clean(path)
+}
+
+// readlink returns the target of a symlink. Only the operating
+// system's filesystem has symlinks to read.
+func readlink(fs afero.Fs, path string) (string, error) {
+	if _, ok := fs.(*afero.OsFs); ok {
+		return os.Readlink(path)
+	}
+	return "", &os.PathError{Op: "readlink", Path: path, Err: os.ErrInvalid}
+}
+
+// outsideRoot reports whether target lies outside root.
+func outsideRoot(root, target string) bool {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, absTarget)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// specialKind names the kind of a special file.
+func specialKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	}
+	return "device"
+}
+
+// Walk calls fn for every regular file under root, or for root itself
+// if it is a regular file. When Archives is set, the members of any
+// archives found are reported after the archive. When FromArchive is
+// set, root must be an archive and only its members are reported.
+func (s *Scanner) Walk(root string, fn scanFunc) error {
+	fn = s.typed(fn)
+	if s.FromArchive {
+		return s.walkArchiveFile(root, root, "", fn)
+	}
+	return s.visit(root, true, func(path string, info os.FileInfo) error {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." && !info.IsDir() {
+			rel = filepath.Base(path)
+		}
+		if info.IsDir() {
+			return fn(&scannedFile{Path: path, Root: root, Rel: rel, Info: info})
+		}
+		f, err := s.Fs.Open(path)
+		if err != nil {
+			if !os.IsPermission(err) {
+				return err
+			}
+			return fn(&scannedFile{Path: path, Root: root, Rel: rel, Info: info, Err: err})
+		}
+		err = fn(&scannedFile{Path: path, Root: root, Rel: rel, Info: info, Reader: f})
+		f.Close()
+		if err != nil || !s.Archives {
+			return err
+		}
+		return s.walkArchiveFile(root, path, rel+"!", fn)
+	})
+}
+
+// walkArchiveFile reports the members of path if it is an archive,
+// giving them relative paths that start with relPrefix. Problems
+// reading an archive are printed rather than ending the scan, except
+// with FromArchive, where the archive is all there is to scan.
+func (s *Scanner) walkArchiveFile(root, path, relPrefix string, fn scanFunc) error {
+	f, err := s.Fs.Open(path)
+	if err != nil {
+		if s.FromArchive {
+			return err
+		}
+		return nil
+	}
+	defer f.Close()
+	budget := &archiveBudget{remaining: s.MaxSize}
+	err = s.walkArchive(root, path, f, 1, budget, func(member *scannedFile) error {
+		member.Rel = relPrefix + strings.TrimPrefix(member.Path, path+"!")
+		return fn(member)
+	})
+	switch err {
+	case nil:
+		return nil
+	case errNotArchive:
+		if s.FromArchive {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	case errArchiveTooLarge:
+		fmt.Fprintf(s.Out, "Stopped reading archive %s: %v\n", path, err)
+		return nil
+	}
+	if scanErr, ok := err.(*scanError); ok {
+		return scanErr.err
+	}
+	fmt.Fprintf(s.Out, "Error reading archive %s: %v\n", path, err)
+	return nil
+}
+
+// wantType reports whether files of type kind are to be scanned.
+func (s *Scanner) wantType(kind string) bool {
+	if len(s.Types) == 0 || contains(s.Types, kind) {
+		return true
+	}
+	return contains(s.Types, "executable") && isExecutableType(kind)
+}
+
+// typed wraps fn to detect the type of each file, skipping files of
+// types that aren't wanted.
+func (s *Scanner) typed(fn scanFunc) scanFunc {
+	if !s.DetectTypes && len(s.Types) == 0 {
+		return fn
+	}
+	return func(f *scannedFile) error {
+		if f.Reader != nil {
+			buffered := bufio.NewReaderSize(f.Reader, typeHeaderSize)
+			header, _ := buffered.Peek(typeHeaderSize)
+			f.Type = detectFileType(f.Path, header)
+			f.Reader = buffered
+			if !s.wantType(f.Type) {
+				return nil
+			}
+		}
+		return fn(f)
+	}
+}
+
+// fileType detects the type of the file at path.
+func (s *Scanner) fileType(path string) (string, error) {
+	f, err := s.Fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := make([]byte, typeHeaderSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return detectFileType(path, header[:n]), nil
+}
+
+// scanError wraps an error returned by a scanFunc while reporting
+// archive members, so it can be told apart from errors reading the
+// archive.
+type scanError struct {
+	err error
+}
+
+func (e *scanError) Error() string {
+	return e.err.Error()
+}
+
+// Count returns the number of files Walk would report for root, not
+// counting archive members.
+func (s *Scanner) Count(root string) int32 {
+	var count int32
+	err := s.visit(root, false, func(path string, info os.FileInfo) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if len(s.Types) > 0 {
+			if kind, err := s.fileType(path); err == nil && !s.wantType(kind) {
+				return nil
+			}
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return count
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestScanSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdd")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "www")
+	outside := filepath.Join(dir, "outside")
+	os.MkdirAll(filepath.Join(root, "inc"), 0755)
+	os.MkdirAll(outside, 0755)
+	ioutil.WriteFile(filepath.Join(root, "index.php"), []byte("<?php"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "inc", "lib.php"), []byte("<?php"), 0644)
+	ioutil.WriteFile(filepath.Join(outside, "shell.php"), []byte("<?php"), 0644)
+	if err := os.Symlink(outside, filepath.Join(root, "cache")); err != nil {
+		t.Skipf("Symlink: %v", err)
+	}
+	// A loop back to the root is walked no further.
+	os.Symlink("..", filepath.Join(root, "inc", "up"))
+	os.Symlink("index.php", filepath.Join(root, "home.php"))
+
+	scan := func(follow bool) []string {
+		scanner := NewScanner(afero.NewOsFs())
+		scanner.FollowSymlinks = follow
+		var paths []string
+		scanner.Walk(root, func(f *scannedFile) error {
+			paths = append(paths, filepath.ToSlash(f.Rel))
+			return nil
+		})
+		sort.Strings(paths)
+		return paths
+	}
+	expected := "inc/lib.php index.php"
+	if paths := scan(false); strings.Join(paths, " ") != expected {
+		t.Errorf("Scanner: Walk: expected: %v actual: %v", expected, paths)
+	}
+	expected = "cache/shell.php home.php inc/lib.php index.php"
+	if paths := scan(true); strings.Join(paths, " ") != expected {
+		t.Errorf("Scanner: Walk: following symlinks: expected: %v actual: %v", expected, paths)
+	}
+
+	if !outsideRoot(root, filepath.Join(outside, "shell.php")) {
+		t.Errorf("outsideRoot: expected %s to be outside %s", outside, root)
+	}
+	if outsideRoot(root, filepath.Join(root, "inc", "..", "index.php")) {
+		t.Errorf("outsideRoot: expected index.php to be inside %s", root)
+	}
+	if kind := specialKind(os.ModeNamedPipe); kind != "named pipe" {
+		t.Errorf("specialKind: expected: named pipe actual: %s", kind)
+	}
+}
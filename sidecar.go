@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/afero"
+)
+
+// sidecarSuffix is appended to the name of a filter file to name its
+// sidecar.
+const sidecarSuffix = ".digests"
+
+// Sidecar holds every element of a filter exactly, so that matches in
+// the filter, which may be false positives, can be confirmed. It is
+// written next to the filter as the elements' binary keys, sorted, so it
+// can be searched without an index. Digests are their own keys, and
+// other elements, such as the paths of path-aware filters, are keyed by
+// their digest. Keys are held back to back in one buffer, as they are
+// stored, so loading a sidecar doesn't allocate per element.
+type Sidecar struct {
+	keys []byte
+	// width is the size of each key, that of a digest of the filter's
+	// algorithm.
+	width  int
+	sorted bool
+}
+
+// sidecarKey returns the key of an element in a filter of digests using
+// hash algorithm alg.
+func sidecarKey(element, alg string) []byte {
+	if digestType(element) == alg {
+		if key, err := hex.DecodeString(element); err == nil {
+			return key
+		}
+	}
+	h := getHasher(alg)
+	h.Write([]byte(element))
+	return h.Sum(nil)
+}
+
+// add records an element of a filter of digests using alg.
+func (s *Sidecar) add(element, alg string) {
+	key := sidecarKey(element, alg)
+	s.width = len(key)
+	s.keys = append(s.keys, key...)
+	s.sorted = false
+}
+
+// key returns the i'th key.
+func (s *Sidecar) key(i int) []byte {
+	return s.keys[i*s.width : (i+1)*s.width]
+}
+
+// sidecarKeys sorts the keys of a sidecar in place.
+type sidecarKeys struct {
+	*Sidecar
+	swap []byte
+}
+
+func (k sidecarKeys) Len() int           { return len(k.keys) / k.width }
+func (k sidecarKeys) Less(i, j int) bool { return bytes.Compare(k.key(i), k.key(j)) < 0 }
+func (k sidecarKeys) Swap(i, j int) {
+	copy(k.swap, k.key(i))
+	copy(k.key(i), k.key(j))
+	copy(k.key(j), k.swap)
+}
+
+// sort sorts the keys and drops duplicates.
+func (s *Sidecar) sort() {
+	if s.sorted || s.width == 0 {
+		return
+	}
+	keys := sidecarKeys{s, make([]byte, s.width)}
+	sort.Sort(keys)
+	unique := 0
+	for i := 0; i < keys.Len(); i++ {
+		if unique == 0 || !bytes.Equal(s.key(i), s.key(unique-1)) {
+			copy(s.key(unique), s.key(i))
+			unique++
+		}
+	}
+	s.keys = s.keys[:unique*s.width]
+	s.sorted = true
+}
+
+// Contains reports whether the sidecar holds an element of a filter of
+// digests using alg.
+func (s *Sidecar) Contains(element, alg string) bool {
+	key := sidecarKey(element, alg)
+	n := s.Len()
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(s.key(i), key) >= 0
+	})
+	return i < n && bytes.Equal(s.key(i), key)
+}
+
+// Len returns the number of elements held.
+func (s *Sidecar) Len() int {
+	s.sort()
+	if s.width == 0 {
+		return 0
+	}
+	return len(s.keys) / s.width
+}
+
+// Save writes the sidecar to path.
+func (s *Sidecar) Save(path string, fs afero.Fs) error {
+	s.sort()
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(s.keys); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadSidecar reads the sidecar of a filter of digests using alg.
+func loadSidecar(path, alg string, fs afero.Fs) (*Sidecar, error) {
+	content, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	width := getHasher(alg).Size()
+	if len(content)%width != 0 {
+		return nil, fmt.Errorf("%s: not a sidecar of %s digests", path, alg)
+	}
+	s := &Sidecar{keys: content, width: width, sorted: true}
+	for i := 1; i < s.Len(); i++ {
+		if bytes.Compare(s.key(i-1), s.key(i)) >= 0 {
+			return nil, fmt.Errorf("%s: digests out of order", path)
+		}
+	}
+	return s, nil
+}
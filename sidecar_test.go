@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestSidecar(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fs.MkdirAll("/tmp/filters", 0755)
+	// A filter far too small for its elements matches most things.
+	bloomFilter := NewBloomFilter(2, 0.5, fs)
+	bloomFilter.Sidecar = &Sidecar{}
+	var members []string
+	for i := 0; i < 50; i++ {
+		digest := md5Hex(fmt.Sprintf("member%d", i))
+		members = append(members, digest)
+		bloomFilter.AddNew(digest)
+	}
+	bloomFilter.Add(pathKey("wp-includes/load.php"))
+	bloomFilter.Save("/tmp/filters/small")
+
+	loaded := NewBloomFilter(1, 0.01, fs)
+	loaded.Load("/tmp/filters/small")
+	if err := loaded.LoadSidecar("/tmp/filters/small"); err != nil {
+		t.Fatalf("LoadSidecar: unexpected error: %v", err)
+	}
+	if loaded.Sidecar.Len() != 51 {
+		t.Errorf("LoadSidecar: expected: 51 elements actual: %d", loaded.Sidecar.Len())
+	}
+	for _, digest := range append(members, pathKey("wp-includes/load.php")) {
+		if !loaded.Contains(digest) {
+			t.Errorf("BloomFilter: Contains: expected %s to be confirmed", digest)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 100; i++ {
+		digest := md5Hex(fmt.Sprintf("other%d", i))
+		if loaded.Lookup(digest) {
+			falsePositives++
+		}
+		if loaded.Contains(digest) {
+			t.Errorf("BloomFilter: Contains: expected %s not to be confirmed", digest)
+		}
+	}
+	if falsePositives == 0 {
+		t.Errorf("BloomFilter: Lookup: expected false positives from an overfull filter")
+	}
+
+	afero.WriteFile(fs, "/tmp/filters/bad"+sidecarSuffix, []byte("short"), 0644)
+	if _, err := loadSidecar("/tmp/filters/bad"+sidecarSuffix, "md5", fs); err == nil {
+		t.Errorf("loadSidecar: expected an error for a truncated sidecar")
+	}
+
+	sidecar := &Sidecar{}
+	for _, digest := range []string{members[2], members[1], members[2]} {
+		sidecar.add(digest, "md5")
+	}
+	if sidecar.Len() != 2 || !sidecar.Contains(members[1], "md5") || sidecar.Contains(members[0], "md5") {
+		t.Errorf("Sidecar: add: expected: 2 distinct elements actual: %d", sidecar.Len())
+	}
+	reversed := append(append([]byte{}, sidecar.key(1)...), sidecar.key(0)...)
+	afero.WriteFile(fs, "/tmp/filters/unsorted"+sidecarSuffix, reversed, 0644)
+	if _, err := loadSidecar("/tmp/filters/unsorted"+sidecarSuffix, "md5", fs); err == nil {
+		t.Errorf("loadSidecar: expected an error for digests out of order")
+	}
+}
+
+func md5Hex(content string) string {
+	h := getHasher("md5")
+	h.Write([]byte(content))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
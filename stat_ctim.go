@@ -0,0 +1,20 @@
+//go:build aix || dragonfly || linux || openbsd || solaris
+// +build aix dragonfly linux openbsd solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the time a file's inode last changed, when the
+// filesystem provides it.
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(stat.Ctim.Unix()), true
+}
@@ -0,0 +1,27 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileID returns the device and inode numbers of a file, which this
+// platform doesn't provide.
+func fileID(info os.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
+
+// fileOwner returns the user id owning a file, which this platform
+// doesn't provide.
+func fileOwner(info os.FileInfo) (uid uint32, ok bool) {
+	return 0, false
+}
+
+// changeTime returns the time a file's inode last changed, which this
+// platform doesn't provide.
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
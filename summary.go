@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dirSummary counts the verdicts on the files under a directory,
+// including those in its subdirectories. Archives count as directories
+// holding their members.
+type dirSummary struct {
+	Name     string `json:"name"`
+	Files    int    `json:"files"`
+	Known    int    `json:"known"`
+	Unknown  int    `json:"unknown"`
+	Modified int    `json:"modified"`
+	KnownBad int    `json:"known_bad"`
+	// UnknownExecutables counts unknown files holding content that
+	// could be run.
+	UnknownExecutables int     `json:"unknown_executables"`
+	PercentKnown       float64 `json:"percent_known"`
+	// Collapsed is set when every file under the directory is known, so
+	// its subdirectories are left out.
+	Collapsed bool          `json:"collapsed,omitempty"`
+	Children  []*dirSummary `json:"children,omitempty"`
+
+	// flagged is set when the directory itself holds known-bad files or
+	// unknown executables, rather than only its subdirectories.
+	flagged  bool
+	children map[string]*dirSummary
+}
+
+// treeSummary aggregates lookup verdicts by directory, so big trees can
+// be read at a glance: directories whose files are all known collapse
+// into one line, leaving the ones with unknown files.
+type treeSummary struct {
+	top dirSummary
+}
+
+// summaryDirs splits a path into the directories holding it. Archive
+// members, named as archive.zip!inner/path, are held by the archive.
+func summaryDirs(path string) []string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	path = strings.Replace(path, "!", "!/", -1)
+	parts := strings.Split(path, "/")
+	dirs := parts[:len(parts)-1]
+	if len(dirs) > 0 && dirs[0] == "" {
+		dirs[0] = "/"
+	}
+	return dirs
+}
+
+// Add counts the verdict on a file of type kind.
+func (t *treeSummary) Add(path, verdict, kind string) {
+	node := &t.top
+	node.count(verdict, kind)
+	for _, name := range summaryDirs(path) {
+		child, ok := node.children[name]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*dirSummary)
+			}
+			child = &dirSummary{Name: name}
+			node.children[name] = child
+		}
+		node = child
+		node.count(verdict, kind)
+	}
+	node.flagged = node.flagged || verdict == verdictKnownBad ||
+		verdict == verdictUnknown && isExecutableType(kind)
+}
+
+// count adds a file's verdict to the directory's counts.
+func (d *dirSummary) count(verdict, kind string) {
+	d.Files++
+	switch verdict {
+	case verdictKnownGood:
+		d.Known++
+	case verdictKnownBad:
+		d.KnownBad++
+	case verdictModified:
+		d.Modified++
+	default:
+		d.Unknown++
+		if isExecutableType(kind) {
+			d.UnknownExecutables++
+		}
+	}
+	d.PercentKnown = float64(d.Known) / float64(d.Files) * 100
+}
+
+// Root returns the summary from the deepest directory holding every file
+// counted, with the subdirectories of each directory in Children, by
+// name, and those of fully known directories left out.
+func (t *treeSummary) Root() *dirSummary {
+	root := &t.top
+	// Directories that only lead to one other are joined into its name.
+	for len(root.children) == 1 && !root.flagged {
+		var child *dirSummary
+		for _, only := range root.children {
+			child = only
+		}
+		if child.Files != root.Files {
+			break
+		}
+		merged := *child
+		switch {
+		case root.Name == "":
+		case strings.HasSuffix(root.Name, "/"), strings.HasSuffix(root.Name, "!"):
+			merged.Name = root.Name + child.Name
+		default:
+			merged.Name = root.Name + "/" + child.Name
+		}
+		root = &merged
+	}
+	if root.Name == "" {
+		root.Name = "."
+	}
+	root.finish()
+	return root
+}
+
+// finish fills in Children and Collapsed from the directories found.
+func (d *dirSummary) finish() {
+	d.Children = nil
+	d.Collapsed = d.Known == d.Files && len(d.children) > 0
+	if d.Collapsed {
+		return
+	}
+	var names []string
+	for name := range d.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := d.children[name]
+		child.finish()
+		d.Children = append(d.Children, child)
+	}
+}
+
+// Print prints the summary as an indented tree, one directory per line.
+// Directories holding known-bad files or unknown executables are marked
+// with [!].
+func (t *treeSummary) Print(w io.Writer) {
+	t.Root().print(w, "")
+}
+
+// Flagged reports whether the directory itself holds known-bad files
+// or unknown executables.
+func (d *dirSummary) Flagged() bool {
+	return d.flagged
+}
+
+// Label returns the directory's name ending in a slash, or in "!" for
+// an archive.
+func (d *dirSummary) Label() string {
+	if strings.HasSuffix(d.Name, "/") || strings.HasSuffix(d.Name, "!") {
+		return d.Name
+	}
+	return d.Name + "/"
+}
+
+func (d *dirSummary) print(w io.Writer, indent string) {
+	mark := "    "
+	if d.flagged {
+		mark = "[!] "
+	}
+	name := d.Label()
+	var details []string
+	for _, detail := range []struct {
+		count int
+		what  string
+	}{
+		{d.Unknown, "unknown"},
+		{d.Modified, "modified"},
+		{d.KnownBad, "known-bad"},
+		{d.UnknownExecutables, "unknown executables"},
+	} {
+		if detail.count > 0 {
+			details = append(details, fmt.Sprintf("%d %s", detail.count, detail.what))
+		}
+	}
+	if d.Collapsed {
+		details = append(details, "all known")
+	}
+	line := fmt.Sprintf(
+		"%s%-40s %7d files %6.1f%% known  %s",
+		mark,
+		indent+name,
+		d.Files,
+		d.PercentKnown,
+		strings.Join(details, ", "),
+	)
+	fmt.Fprintln(w, strings.TrimRight(line, " "))
+	for _, child := range d.Children {
+		child.print(w, indent+"  ")
+	}
+}
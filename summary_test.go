@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestTreeSummary(t *testing.T) {
+	var summary treeSummary
+	summary.Add("/var/www/html/index.php", verdictKnownGood, "php")
+	summary.Add("/var/www/html/wp-admin/admin.php", verdictKnownGood, "php")
+	summary.Add("/var/www/html/wp-admin/css/admin.css", verdictKnownGood, "text")
+	summary.Add("/var/www/html/uploads/shell.jpg", verdictUnknown, "php")
+	summary.Add("/var/www/html/uploads/photo.jpg", verdictUnknown, "jpeg")
+	summary.Add("/var/www/html/uploads/theme.zip!theme/evil.php", verdictKnownBad, "php")
+
+	root := summary.Root()
+	if root.Name != "/var/www/html" {
+		t.Errorf("treeSummary: Root: expected: /var/www/html actual: %s", root.Name)
+	}
+	if root.Files != 6 || root.Known != 3 || root.Unknown != 2 || root.KnownBad != 1 {
+		t.Errorf("treeSummary: Root: expected: 6 files, 3 known, 2 unknown, 1 known-bad actual: %+v", root)
+	}
+	if root.PercentKnown != 50 {
+		t.Errorf("treeSummary: Root: expected: 50%% known actual: %.1f%%", root.PercentKnown)
+	}
+	if len(root.Children) != 2 {
+		t.Fatalf("treeSummary: Root: expected: 2 children actual: %d", len(root.Children))
+	}
+
+	uploads, admin := root.Children[0], root.Children[1]
+	if uploads.Name != "uploads" || uploads.UnknownExecutables != 1 || !uploads.flagged {
+		t.Errorf("treeSummary: uploads: expected a flagged directory with 1 unknown executable actual: %+v", uploads)
+	}
+	if admin.Name != "wp-admin" || !admin.Collapsed || len(admin.Children) != 0 {
+		t.Errorf("treeSummary: wp-admin: expected a collapsed directory actual: %+v", admin)
+	}
+	if len(uploads.Children) != 1 || uploads.Children[0].Name != "theme.zip!" {
+		t.Fatalf("treeSummary: uploads: expected the archive as a child actual: %+v", uploads.Children)
+	}
+	if archive := uploads.Children[0]; archive.KnownBad != 1 || archive.flagged {
+		t.Errorf("treeSummary: theme.zip: expected 1 known-bad file in a subdirectory actual: %+v", archive)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// wordpressDocument covers both checksum documents published by
+// WordPress.org. Core checksums from api.wordpress.org/core/checksums
+// map file paths to MD5 digests, either directly for a single version
+// or keyed by version when several were requested:
+//
+//	{"checksums": {"wp-admin/about.php": "<md5>", ...}}
+//	{"checksums": {"6.4": {"wp-admin/about.php": "<md5>", ...}}}
+//
+// Plugin checksums from downloads.wordpress.org/plugin-checksums list
+// md5 and sha256 digests per file, where a digest may be a string or a
+// list of strings:
+//
+//	{"plugin": "akismet", "version": "5.3", "files": {
+//	    "akismet.php": {"md5": "<md5>", "sha256": "<sha256>"}}}
+type wordpressDocument struct {
+	Checksums json.RawMessage                       `json:"checksums"`
+	Plugin    string                                `json:"plugin"`
+	Version   string                                `json:"version"`
+	Files     map[string]map[string]json.RawMessage `json:"files"`
+	// Error and Message hold the reason given when the server answered
+	// with an error status rather than checksums.
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// wordpressChecksums reads a saved checksums document and returns the
+// digests of type alg it lists, keyed by version label. Core documents
+// only list md5 digests. Core documents for a single version don't say
+// which version they describe, so their digests are labeled with
+// version. Documents covering several versions are narrowed down to
+// version when it is given. Plugin digests are labeled with the plugin
+// name and version, e.g. "akismet-5.3".
+func wordpressChecksums(path, version, alg string, fs afero.Fs) (map[string][]string, error) {
+	content, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	var doc wordpressDocument
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("%s: JSON unmarshaling failed: %v", path, err)
+	}
+	switch {
+	case doc.Error != "":
+		return nil, fmt.Errorf("%s: error from WordPress.org: %s", path, doc.Error)
+	case doc.Message != "" && doc.Files == nil && doc.Checksums == nil:
+		return nil, fmt.Errorf("%s: error from WordPress.org: %s", path, doc.Message)
+	}
+	labeled := make(map[string][]string)
+	if doc.Files != nil {
+		label := strings.Trim(doc.Plugin+"-"+doc.Version, "-")
+		for name, digests := range doc.Files {
+			raw, ok := digests[alg]
+			if !ok {
+				return nil, fmt.Errorf("%s: no %s digest for %s", path, alg, name)
+			}
+			decoded, err := wordpressDigests(raw, alg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s digest for %s: %v", path, alg, name, err)
+			}
+			labeled[label] = append(labeled[label], decoded...)
+		}
+		return labeled, nil
+	}
+
+	// The core checksums API answers {"checksums": false} for versions
+	// it doesn't know.
+	if doc.Checksums == nil || string(doc.Checksums) == "false" {
+		return nil, fmt.Errorf("%s lists no checksums", path)
+	}
+	if alg != "md5" {
+		return nil, fmt.Errorf("%s: core checksums only list md5 digests, not %s", path, alg)
+	}
+	var files map[string]string
+	if json.Unmarshal(doc.Checksums, &files) == nil {
+		for name, digest := range files {
+			digest, err := wordpressDigest(digest, alg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s digest for %s: %v", path, alg, name, err)
+			}
+			labeled[version] = append(labeled[version], digest)
+		}
+		return labeled, nil
+	}
+	var versions map[string]map[string]string
+	if err := json.Unmarshal(doc.Checksums, &versions); err != nil {
+		return nil, fmt.Errorf("%s is not a WordPress checksums document", path)
+	}
+	for label, files := range versions {
+		if version != "" && label != version {
+			continue
+		}
+		for name, digest := range files {
+			digest, err := wordpressDigest(digest, alg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s digest for %s: %v", path, alg, name, err)
+			}
+			labeled[label] = append(labeled[label], digest)
+		}
+	}
+	return labeled, nil
+}
+
+// wordpressDigests decodes a plugin checksum, which is either a single
+// digest or a list of them for files that differ between builds.
+func wordpressDigests(raw json.RawMessage, alg string) ([]string, error) {
+	var digests []string
+	var digest string
+	if json.Unmarshal(raw, &digest) == nil {
+		digests = []string{digest}
+	} else if err := json.Unmarshal(raw, &digests); err != nil {
+		return nil, err
+	}
+	for i := range digests {
+		var err error
+		if digests[i], err = wordpressDigest(digests[i], alg); err != nil {
+			return nil, err
+		}
+	}
+	return digests, nil
+}
+
+// wordpressDigest lower-cases a listed digest, checking that it is an
+// alg digest. A document listing something else under that name would
+// otherwise fill the filter with digests no file ever has.
+func wordpressDigest(digest, alg string) (string, error) {
+	digest = strings.ToLower(digest)
+	if digestType(digest) != alg {
+		return "", fmt.Errorf("%q is not a %s digest", digest, alg)
+	}
+	return digest, nil
+}
+
+// wordpressFilterFiles decides which filter file each version label's
+// digests go to. Unless split is set, every label shares filterFile.
+// When it is, each label gets its own filter named filterFile-label.
+func wordpressFilterFiles(filterFile string, labeled map[string][]string, split bool) map[string][]string {
+	byFile := make(map[string][]string)
+	var labels []string
+	for label := range labeled {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		target := filterFile
+		if split && label != "" {
+			target = fmt.Sprintf("%s-%s", filterFile, label)
+		}
+		byFile[target] = append(byFile[target], labeled[label]...)
+	}
+	return byFile
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+const coreChecksums = `{"checksums": {
+	"wp-admin/about.php": "F3A4C1E5B4D1C2B8A9E0F1D2C3B4A5E6",
+	"index.php": "926dd0f95df723f9ed934eb058882cc8"
+}}`
+
+const multiCoreChecksums = `{"checksums": {
+	"6.3": {"index.php": "926dd0f95df723f9ed934eb058882cc8"},
+	"6.4": {"wp-login.php": "0a3ad2a8b8ab2fd0b8fc8c0e0e35ad0d"}
+}}`
+
+const pluginChecksums = `{
+	"plugin": "akismet",
+	"version": "5.3",
+	"files": {
+		"akismet.php": {
+			"md5": "1b9ad1e5b3b8c3ff8d0e0bd5de1e5a28",
+			"sha256": "2f6c2f59a4ea6a1a0d4bbc7a3bfe3ac9d0d1d8c1a0f7f2bb3a3a2d2b4f3f1a0c"
+		},
+		"readme.txt": {
+			"md5": ["a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5", "b0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5"],
+			"sha256": "3f6c2f59a4ea6a1a0d4bbc7a3bfe3ac9d0d1d8c1a0f7f2bb3a3a2d2b4f3f1a0c"
+		}
+	}
+}`
+
+func TestWordpressChecksums(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	afero.WriteFile(fs, "/tmp/core.json", []byte(coreChecksums), 0644)
+	afero.WriteFile(fs, "/tmp/multi.json", []byte(multiCoreChecksums), 0644)
+	afero.WriteFile(fs, "/tmp/plugin.json", []byte(pluginChecksums), 0644)
+
+	core, _ := wordpressChecksums("/tmp/core.json", "6.4", "md5", fs)
+	if len(core["6.4"]) != 2 {
+		t.Errorf(
+			"wordpressChecksums: core: expected: %d actual: %d",
+			2,
+			len(core["6.4"]),
+		)
+	}
+	for _, digest := range core["6.4"] {
+		if !isMD5(digest) || digest != strings.ToLower(digest) {
+			t.Errorf("wordpressChecksums: core: expected lower case MD5 actual: %s", digest)
+		}
+	}
+
+	multi, _ := wordpressChecksums("/tmp/multi.json", "", "md5", fs)
+	if len(multi["6.3"]) != 1 || len(multi["6.4"]) != 1 {
+		t.Errorf("wordpressChecksums: multiple versions: actual: %v", multi)
+	}
+	multi, _ = wordpressChecksums("/tmp/multi.json", "6.3", "md5", fs)
+	if _, ok := multi["6.4"]; ok {
+		t.Errorf("wordpressChecksums: version 6.3: unexpected 6.4 digests: %v", multi)
+	}
+
+	plugin, _ := wordpressChecksums("/tmp/plugin.json", "", "md5", fs)
+	if len(plugin["akismet-5.3"]) != 3 {
+		t.Errorf(
+			"wordpressChecksums: plugin: expected: %d actual: %d",
+			3,
+			len(plugin["akismet-5.3"]),
+		)
+	}
+	plugin, _ = wordpressChecksums("/tmp/plugin.json", "", "sha256", fs)
+	if len(plugin["akismet-5.3"]) != 2 || digestType(plugin["akismet-5.3"][0]) != "sha256" {
+		t.Errorf("wordpressChecksums: plugin sha256: expected 2 sha256 digests actual: %v", plugin)
+	}
+}
+
+func TestWordpressChecksumsErrors(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	afero.WriteFile(fs, "/tmp/core.json", []byte(coreChecksums), 0644)
+	afero.WriteFile(fs, "/tmp/plugin.json", []byte(pluginChecksums), 0644)
+	afero.WriteFile(fs, "/tmp/unknown.json", []byte(`{"checksums": false}`), 0644)
+	afero.WriteFile(fs, "/tmp/404.json", []byte(`{"error": "Not found"}`), 0644)
+	afero.WriteFile(fs, "/tmp/404.html", []byte("<html>Not Found</html>"), 0644)
+	afero.WriteFile(fs, "/tmp/bad-digest.json", []byte(`{"plugin": "x", "files": {"x.php": {"md5": 5}}}`), 0644)
+	afero.WriteFile(fs, "/tmp/wrong-digest.json", []byte(`{"plugin": "x", "files": {"x.php": {
+		"md5": "2f6c2f59a4ea6a1a0d4bbc7a3bfe3ac9d0d1d8c1a0f7f2bb3a3a2d2b4f3f1a0c"}}}`), 0644)
+	afero.WriteFile(fs, "/tmp/not-hex.json", []byte(`{"checksums": {"index.php": "not a digest"}}`), 0644)
+
+	tests := []struct {
+		path string
+		alg  string
+	}{
+		{"/tmp/core.json", "sha256"},
+		{"/tmp/plugin.json", "sha1"},
+		{"/tmp/unknown.json", "md5"},
+		{"/tmp/404.json", "md5"},
+		{"/tmp/404.html", "md5"},
+		{"/tmp/bad-digest.json", "md5"},
+		{"/tmp/wrong-digest.json", "md5"},
+		{"/tmp/not-hex.json", "md5"},
+		{"/tmp/missing.json", "md5"},
+	}
+	for _, test := range tests {
+		if _, err := wordpressChecksums(test.path, "", test.alg, fs); err == nil {
+			t.Errorf("wordpressChecksums: %s %s: expected an error", test.path, test.alg)
+		}
+	}
+}
+
+func TestImportWordpressSplit(t *testing.T) {
+	var fs = afero.NewMemMapFs()
+	fs.MkdirAll("/tmp/filters/", 0755)
+	afero.WriteFile(fs, "/tmp/multi.json", []byte(multiCoreChecksums), 0644)
+
+	args := []string{"mdd", "import", "wordpress", "--split", "/tmp/filters/wordpress", "/tmp/multi.json"}
+	parser := Parser{Args: args, Fs: fs}
+	parser.Import()
+
+	for filterFile, digest := range map[string]string{
+		"/tmp/filters/wordpress-6.3": "926dd0f95df723f9ed934eb058882cc8",
+		"/tmp/filters/wordpress-6.4": "0a3ad2a8b8ab2fd0b8fc8c0e0e35ad0d",
+	} {
+		bloomFilter := NewBloomFilter(1, 0.01, fs)
+		bloomFilter.Load(filterFile)
+		if !bloomFilter.Lookup(digest) {
+			t.Errorf("Import: wordpress: %s expected to find: %s", filterFile, digest)
+		}
+	}
+}